@@ -0,0 +1,422 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "crypto/tls"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "net"
+        "net/http"
+        "net/http/cookiejar"
+        "net/url"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// Cluster is a configured CUCM cluster and the requests cm-gator currently has open to it
+type Cluster struct {
+        Name string
+        AXL  AXLConfig
+
+        // nodes is the publisher followed by the subscribers, in configuration order
+        nodes []*clusterNode
+        // jar keeps each node's CUCM session cookies across requests
+        jar http.CookieJar
+        // sched limits the requests in flight to AXL.MaxConcurrent, interactive ones first
+        sched *axlScheduler
+        // versionMu guards AXL.Version while it is "auto" and the detection state below; once set, the version never changes
+        versionMu sync.Mutex
+        // versionDone is closed when the running detection ends, and is nil while none runs
+        versionDone chan struct{}
+        // versionErr is the last detection failure, returned without probing again until versionRetry
+        versionErr   error
+        versionRetry time.Time
+}
+
+// clusterNode is one CUCM server of a cluster; a node that could not be reached is skipped
+// until AXL.HealthRetry has passed
+type clusterNode struct {
+        Host      string
+        Port      int
+        Publisher bool
+        url       *url.URL
+        // client is shared by every request to the node so connections and the CUCM session are reused
+        client *http.Client
+
+        mu        sync.Mutex
+        downUntil time.Time
+        lastErr   string
+}
+
+// NodeInfo structure for the nodes listed by GET /clusters
+type NodeInfo struct {
+        Host      string     `json:"host"`
+        Port      int        `json:"port"`
+        Role      string     `json:"role"`
+        Healthy   bool       `json:"healthy"`
+        DownUntil *time.Time `json:"downUntil,omitempty"`
+        LastError string     `json:"lastError,omitempty"`
+}
+
+// ClusterInfo structure for GET /clusters
+type ClusterInfo struct {
+        Name          string         `json:"name"`
+        Host          string         `json:"host"`
+        Version       string         `json:"axlVersion"`
+        MaxConcurrent int            `json:"maxConcurrent"`
+        Default       bool           `json:"default"`
+        Nodes         []NodeInfo     `json:"nodes"`
+        InFlight      int            `json:"inFlight"`
+        InFlightBulk  int            `json:"inFlightBulk"`
+        Waiting       map[string]int `json:"waiting"`
+}
+
+// Context keys for the target cluster and for fan-out requests
+type clusterKey struct{}
+type fanOutKey struct{}
+
+// Cluster name that runs a list or report on every cluster
+const clusterAll = "all"
+
+// Header that selects a cluster when the /clusters/{name} prefix is not used
+const clusterHeader = "X-CUCM-Cluster"
+
+// Session cookies CUCM sets after a successful login; while the jar holds one, credentials are not resent
+var axlSessionCookies = []string{"JSESSIONIDSSO", "JSESSIONID"}
+
+// Node failures that handlers can test for with errors.Is
+var (
+        ErrPublisherUnavailable = errors.New("publisher unavailable")
+        ErrNoNodeAvailable      = errors.New("no AXL node available")
+        // no connection could be made: refused, failed to dial or failed the TLS handshake; only this marks a node down
+        ErrNodeUnreachable = errors.New("node unreachable")
+        ErrAXLTimeout      = errors.New("AXL request timed out")
+)
+
+// Routes that accept cluster "all"; everything else needs a single cluster
+var fanOutRoutes = map[string]bool{
+        "/listUsers":        true,
+        "/phones":           true,
+        "/lines":            true,
+        "/reports/location": true,
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to create the runtime state for a cluster
+func newCluster(name string, axl AXLConfig) *Cluster {
+        jar, _ := cookiejar.New(nil)
+        cl := &Cluster{Name: name, AXL: axl, jar: jar, sched: newAXLScheduler(axl.MaxConcurrent)}
+        cl.nodes = append(cl.nodes, cl.newNode(axl.Host, axl.Port, true))
+        for _, sub := range axl.Subscribers {
+                host, port, _ := splitNodeAddr(sub, axl.Port)
+                cl.nodes = append(cl.nodes, cl.newNode(host, port, false))
+        }
+        return cl
+}
+
+// Function to create a node, its AXL URL and its HTTP client
+func (cl *Cluster) newNode(host string, port int, publisher bool) *clusterNode {
+        n := &clusterNode{Host: host, Port: port, Publisher: publisher}
+        n.url, _ = url.Parse(fmt.Sprintf("https://%s/axl/", net.JoinHostPort(host, strconv.Itoa(port))))
+        n.client = newAXLClient(cl.AXL, cl.jar, cl.AXL.TLS.clientConfig(host))
+        return n
+}
+
+// Function to build the long-lived HTTP client for a node: keep-alive connections are pooled
+// up to maxConcurrent and the cookie jar keeps the node's CUCM session
+func newAXLClient(axl AXLConfig, jar http.CookieJar, tlsConfig *tls.Config) *http.Client {
+        return &http.Client{
+                Jar:     jar,
+                Timeout: axl.Timeouts.Request,
+                Transport: &http.Transport{
+                        DialContext:           (&net.Dialer{Timeout: axl.Timeouts.Connect, KeepAlive: 30 * time.Second}).DialContext,
+                        TLSHandshakeTimeout:   axl.Timeouts.Connect,
+                        ResponseHeaderTimeout: axl.Timeouts.Response,
+                        IdleConnTimeout:       axl.Timeouts.Idle,
+                        MaxIdleConnsPerHost:   axl.MaxConcurrent,
+                        TLSClientConfig:       tlsConfig,
+                },
+        }
+}
+
+// Function to report whether the cluster holds a CUCM session for the node
+func (cl *Cluster) hasSession(n *clusterNode) bool {
+        for _, c := range cl.jar.Cookies(n.url) {
+                for _, name := range axlSessionCookies {
+                        if c.Name == name {
+                                return true
+                        }
+                }
+        }
+        return false
+}
+
+// Function to forget the node's CUCM session so the next request logs in again
+func (cl *Cluster) clearSession(n *clusterNode) {
+        expired := make([]*http.Cookie, 0, len(axlSessionCookies))
+        for _, name := range axlSessionCookies {
+                expired = append(expired, &http.Cookie{Name: name, Path: "/", MaxAge: -1})
+        }
+        cl.jar.SetCookies(n.url, expired)
+}
+
+// Function to attach a target cluster to a context
+func withCluster(ctx context.Context, cl *Cluster) context.Context {
+        return context.WithValue(ctx, clusterKey{}, cl)
+}
+
+// Function to find the cluster a request targets, falling back to the default cluster
+func clusterFrom(ctx context.Context) *Cluster {
+        if cl, ok := ctx.Value(clusterKey{}).(*Cluster); ok {
+                return cl
+        }
+        return config.clusters[config.DefaultCluster]
+}
+
+// Function to report whether a request asked for every cluster
+func isFanOut(ctx context.Context) bool {
+        all, _ := ctx.Value(fanOutKey{}).(bool)
+        return all
+}
+
+// Function to list the clusters in configuration order
+func allClusters() []*Cluster {
+        clusters := make([]*Cluster, 0, len(config.clusterOrder))
+        for _, name := range config.clusterOrder {
+                clusters = append(clusters, config.clusters[name])
+        }
+        return clusters
+}
+
+// Function to wait for a free request slot on the cluster in the request's lane; the returned func gives it back
+func (cl *Cluster) acquire(ctx context.Context) (func(), error) {
+        return cl.sched.acquire(ctx, laneFrom(ctx))
+}
+
+// Function to report whether an AXL operation only reads, so any node of the cluster can answer it
+func isReadOperation(op string) bool {
+        return strings.HasPrefix(op, "get") || strings.HasPrefix(op, "list") || op == "executeSQLQuery"
+}
+
+// Function to pick the nodes an operation may be sent to, in the order they are tried: writes only
+// go to the publisher and fail at once while it is marked down, reads go to every healthy node
+func (cl *Cluster) nodesFor(op string) ([]*clusterNode, error) {
+        if !isReadOperation(op) {
+                publisher := cl.nodes[0]
+                if !publisher.healthy() {
+                        return nil, publisher.unavailable()
+                }
+                return cl.nodes[:1], nil
+        }
+
+        var healthy []*clusterNode
+        for _, n := range cl.nodes {
+                if n.healthy() {
+                        healthy = append(healthy, n)
+                }
+        }
+        // with every node marked down they are all tried rather than failing without a request
+        if len(healthy) == 0 {
+                return cl.nodes, nil
+        }
+        return healthy, nil
+}
+
+// Function to name the node's role in the cluster
+func (n *clusterNode) role() string {
+        if n.Publisher {
+                return "publisher"
+        }
+        return "subscriber"
+}
+
+// Function to report whether the node may be tried
+func (n *clusterNode) healthy() bool {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        return !time.Now().Before(n.downUntil)
+}
+
+// Function to skip the node for the retry period after it could not be reached
+func (n *clusterNode) markDown(err error, retry time.Duration) {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        n.downUntil = time.Now().Add(retry)
+        n.lastErr = err.Error()
+}
+
+// Function to clear the node's failure once it has answered
+func (n *clusterNode) markUp() {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        n.downUntil = time.Time{}
+        n.lastErr = ""
+}
+
+// Function to build the error returned for writes while the publisher is down
+func (n *clusterNode) unavailable() error {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        return fmt.Errorf("%w: %s is not answering (%s); writes are not sent to subscribers", ErrPublisherUnavailable, n.Host, n.lastErr)
+}
+
+// Function to describe the node for GET /clusters
+func (n *clusterNode) info() NodeInfo {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        info := NodeInfo{Host: n.Host, Port: n.Port, Role: n.role(), Healthy: !time.Now().Before(n.downUntil)}
+        if !info.Healthy {
+                downUntil := n.downUntil.UTC()
+                info.DownUntil = &downUntil
+                info.LastError = n.lastErr
+        }
+        return info
+}
+
+// Function to run a list on every cluster at once and tag each row with its cluster name;
+// list returns the rows and how many the AXL page held, which sets the next cursor
+func fanOut(ctx context.Context, list func(ctx context.Context) (interface{}, int, error)) ([]map[string]interface{}, int, error) {
+        clusters := allClusters()
+        type result struct {
+                rows     []map[string]interface{}
+                returned int
+                err      error
+        }
+        results := make([]result, len(clusters))
+
+        var wg sync.WaitGroup
+        for i, cl := range clusters {
+                wg.Add(1)
+                go func(i int, cl *Cluster) {
+                        defer wg.Done()
+                        rows, returned, err := list(withCluster(ctx, cl))
+                        if err != nil {
+                                results[i].err = fmt.Errorf("cluster %s: %w", cl.Name, err)
+                                return
+                        }
+                        results[i].rows, results[i].err = tagRows(rows, cl.Name)
+                        results[i].returned = returned
+                }(i, cl)
+        }
+        wg.Wait()
+
+        all := []map[string]interface{}{}
+        most := 0
+        for _, r := range results {
+                if r.err != nil {
+                        return nil, 0, r.err
+                }
+                all = append(all, r.rows...)
+                if r.returned > most {
+                        most = r.returned
+                }
+        }
+        return all, most, nil
+}
+
+// Function to run a list on the request's cluster, or on every cluster when cluster "all" was asked for
+func listOnClusters(ctx context.Context, list func(ctx context.Context) (interface{}, int, error)) (interface{}, int, error) {
+        if !isFanOut(ctx) {
+                return list(ctx)
+        }
+        return fanOut(ctx, list)
+}
+
+// Function to turn a slice of rows into JSON objects carrying a cluster field
+func tagRows(rows interface{}, cluster string) ([]map[string]interface{}, error) {
+        data, err := json.Marshal(rows)
+        if err != nil {
+                return nil, err
+        }
+        var tagged []map[string]interface{}
+        if err := json.Unmarshal(data, &tagged); err != nil {
+                return nil, err
+        }
+        for _, row := range tagged {
+                row["cluster"] = cluster
+        }
+        return tagged, nil
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Function to route a request to a cluster named by a /clusters/{name}/ prefix or the X-CUCM-Cluster header
+func clusterRouter(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                name := r.Header.Get(clusterHeader)
+                if rest, ok := strings.CutPrefix(r.URL.Path, "/clusters/"); ok {
+                        name, rest, _ = strings.Cut(rest, "/")
+                        r.URL.Path = "/" + rest
+                        r.URL.RawPath = ""
+                }
+
+                switch {
+                case name == "":
+                        next.ServeHTTP(w, r)
+                case name == clusterAll:
+                        if !fanOutRoutes[r.URL.Path] || r.Method != http.MethodGet {
+                                errorResponse(w, http.StatusBadRequest, `Cluster "all" is only supported by GET /listUsers, /phones, /lines and /reports/location`, nil)
+                                return
+                        }
+                        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), fanOutKey{}, true)))
+                case config.clusters[name] != nil:
+                        next.ServeHTTP(w, r.WithContext(withCluster(r.Context(), config.clusters[name])))
+                default:
+                        errorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown cluster %q", name), nil)
+                }
+        })
+}
+
+// Handler function for listing the configured clusters
+func handleClustersRequest(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+                return
+        }
+
+        infos := make([]ClusterInfo, 0, len(config.clusterOrder))
+        for _, cl := range allClusters() {
+                nodes := make([]NodeInfo, 0, len(cl.nodes))
+                for _, n := range cl.nodes {
+                        nodes = append(nodes, n.info())
+                }
+                inFlight, bulk, waiting := cl.sched.stats()
+                infos = append(infos, ClusterInfo{
+                        Name:          cl.Name,
+                        Host:          cl.AXL.Host,
+                        Version:       cl.axlVersion(),
+                        MaxConcurrent: cl.AXL.MaxConcurrent,
+                        Default:       cl.Name == config.DefaultCluster,
+                        Nodes:         nodes,
+                        InFlight:      inFlight,
+                        InFlightBulk:  bulk,
+                        Waiting:       waiting,
+                })
+        }
+
+        jsonResponse(w, http.StatusOK, "Clusters retrieved successfully", infos)
+}
@@ -0,0 +1,540 @@
+// Command axlgen reads the CUCM AXL schema (AXLAPI.wsdl and AXLSoap.xsd from the AXL SQL Toolkit) and
+// writes Go request/response structs for the add/get/update/list/remove operations of one AXL version.
+//
+// Usage:
+//
+//      go run ./cmd/axlgen -schema schema/14.0 -version 14.0 -out axl/axl_gen.go
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "bytes"
+        "encoding/xml"
+        "flag"
+        "fmt"
+        "go/format"
+        "log"
+        "os"
+        "path/filepath"
+        "regexp"
+        "sort"
+        "strconv"
+        "strings"
+        "unicode"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// xsdNode is any element of the schema, kept generic so child order (sequence/choice/attribute) is preserved
+type xsdNode struct {
+        XMLName  xml.Name
+        Attrs    []xml.Attr `xml:",any,attr"`
+        Children []xsdNode  `xml:",any"`
+}
+
+// goType is one struct to be written
+type goType struct {
+        Name    string
+        Source  string
+        XMLName string
+        Fields  []goField
+        // Shared types back several operations (e.g. StandardResponse), so they carry no XMLName
+        Shared bool
+}
+
+// goField is one struct field
+type goField struct {
+        Name string
+        Type string
+        Tag  string
+}
+
+// generator holds the schema definitions and the structs built from them
+type generator struct {
+        complexTypes map[string]*xsdNode
+        simpleTypes  map[string]*xsdNode
+        elements     map[string]*xsdNode
+        types        map[string]*goType
+}
+
+var nonIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+/****
+*
+* Functions
+*
+*/
+
+func main() {
+        schemaDir := flag.String("schema", "schema/14.0", "directory holding AXLAPI.wsdl and AXLSoap.xsd for the chosen AXL version")
+        version := flag.String("version", "14.0", "AXL version the schema belongs to")
+        out := flag.String("out", "axl_gen.go", "file to write")
+        pkg := flag.String("package", "axl", "package name of the generated file")
+        ops := flag.String("ops", `^(add|get|update|list|remove)[A-Z]`, "regexp selecting the top-level operations to generate")
+        flag.Parse()
+
+        opsPattern, err := regexp.Compile(*ops)
+        if err != nil {
+                log.Fatalf("-ops: %v", err)
+        }
+
+        g := newGenerator()
+        count, err := g.generate(*schemaDir, opsPattern)
+        if err != nil {
+                log.Fatal(err)
+        }
+
+        src, err := g.render(*pkg, *version)
+        if err != nil {
+                log.Fatal(err)
+        }
+        if err := os.MkdirAll(filepath.Dir(*out), 0755); err != nil {
+                log.Fatal(err)
+        }
+        if err := os.WriteFile(*out, src, 0644); err != nil {
+                log.Fatal(err)
+        }
+        log.Printf("wrote %d operations and %d types to %s", count, len(g.types), *out)
+}
+
+// Function to create an empty generator
+func newGenerator() *generator {
+        return &generator{
+                complexTypes: make(map[string]*xsdNode),
+                simpleTypes:  make(map[string]*xsdNode),
+                elements:     make(map[string]*xsdNode),
+                types:        make(map[string]*goType),
+        }
+}
+
+// Function to build the structs of every operation in a schema directory that matches ops, returning how
+// many there were. The operations are those of the AXL port in AXLAPI.wsdl; without the WSDL every
+// top-level element of AXLSoap.xsd whose name matches is taken as one
+func (g *generator) generate(schemaDir string, ops *regexp.Regexp) (int, error) {
+        var elements []string
+        wsdl := filepath.Join(schemaDir, "AXLAPI.wsdl")
+        if _, err := os.Stat(wsdl); err == nil {
+                if elements, err = g.loadWSDL(wsdl, ops); err != nil {
+                        return 0, err
+                }
+        } else {
+                if err := g.load(filepath.Join(schemaDir, "AXLSoap.xsd"), make(map[string]bool)); err != nil {
+                        return 0, err
+                }
+                for _, name := range sortedKeys(g.elements) {
+                        if ops.MatchString(name) {
+                                elements = append(elements, name)
+                        }
+                }
+        }
+
+        for _, name := range elements {
+                if _, ok := g.elements[name]; !ok {
+                        return 0, fmt.Errorf("element %s is not in the schema", name)
+                }
+                if err := g.operation(name); err != nil {
+                        return 0, err
+                }
+        }
+        if len(elements) == 0 {
+                return 0, fmt.Errorf("no operations in %s match %q", schemaDir, ops)
+        }
+        return len(elements), nil
+}
+
+// Function to read the WSDL, load the schema it imports and list the request and response elements of
+// the port operations that match ops
+func (g *generator) loadWSDL(path string, ops *regexp.Regexp) ([]string, error) {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return nil, err
+        }
+        var defs xsdNode
+        if err := xml.Unmarshal(data, &defs); err != nil {
+                return nil, fmt.Errorf("%s: %v", path, err)
+        }
+
+        seen := make(map[string]bool)
+        messages := make(map[string]string)
+        var elements []string
+        for i := range defs.Children {
+                n := &defs.Children[i]
+                switch n.XMLName.Local {
+                case "types":
+                        for j := range n.Children {
+                                if err := g.loadSchema(path, &n.Children[j], seen); err != nil {
+                                        return nil, err
+                                }
+                        }
+                case "message":
+                        if part := n.child("part"); part != nil {
+                                messages[n.attr("name")] = localName(part.attr("element"))
+                        }
+                case "portType":
+                        for _, op := range n.Children {
+                                if op.XMLName.Local != "operation" || !ops.MatchString(op.attr("name")) {
+                                        continue
+                                }
+                                for _, io := range []string{"input", "output"} {
+                                        msg := op.child(io)
+                                        if msg == nil {
+                                                continue
+                                        }
+                                        el, ok := messages[localName(msg.attr("message"))]
+                                        if !ok {
+                                                return nil, fmt.Errorf("%s: operation %s has no message %s", path, op.attr("name"), msg.attr("message"))
+                                        }
+                                        elements = append(elements, el)
+                                }
+                        }
+                }
+        }
+        sort.Strings(elements)
+        return elements, nil
+}
+
+// Function to read a schema file and the files it includes or imports
+func (g *generator) load(path string, seen map[string]bool) error {
+        if seen[path] {
+                return nil
+        }
+        seen[path] = true
+
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return err
+        }
+        var schema xsdNode
+        if err := xml.Unmarshal(data, &schema); err != nil {
+                return fmt.Errorf("%s: %v", path, err)
+        }
+        return g.loadSchema(path, &schema, seen)
+}
+
+// Function to collect the definitions of a schema read from path, following its includes and imports
+func (g *generator) loadSchema(path string, schema *xsdNode, seen map[string]bool) error {
+        for i := range schema.Children {
+                n := &schema.Children[i]
+                switch n.XMLName.Local {
+                case "include", "import":
+                        if loc := n.attr("schemaLocation"); loc != "" {
+                                if err := g.load(filepath.Join(filepath.Dir(path), loc), seen); err != nil {
+                                        return err
+                                }
+                        }
+                case "complexType":
+                        g.complexTypes[n.attr("name")] = n
+                case "simpleType":
+                        g.simpleTypes[n.attr("name")] = n
+                case "element":
+                        g.elements[n.attr("name")] = n
+                }
+        }
+        return nil
+}
+
+// Function to generate the struct for a top-level operation element such as addPhone or getPhoneResponse
+func (g *generator) operation(name string) error {
+        el := g.elements[name]
+        xmlName := name
+        if !strings.HasSuffix(name, "Response") {
+                // requests are marshalled inside the envelope that declares the axl prefix
+                xmlName = "axl:" + name
+        }
+
+        typeName := localName(el.attr("type"))
+        if typeName == "" {
+                ct := el.child("complexType")
+                if ct == nil {
+                        return fmt.Errorf("element %s has no type", name)
+                }
+                t := g.structType(exported(name), ct)
+                t.XMLName = xmlName
+                return nil
+        }
+
+        ct, ok := g.complexTypes[typeName]
+        if !ok {
+                return fmt.Errorf("element %s: unknown type %s", name, typeName)
+        }
+        t := g.structType(exported(typeName), ct)
+        switch {
+        case t.Shared:
+        case t.XMLName == "":
+                t.XMLName = xmlName
+        case t.XMLName != xmlName:
+                t.XMLName = ""
+                t.Shared = true
+        }
+        return nil
+}
+
+// Function to build (once) the struct for a complex type
+func (g *generator) structType(goName string, ct *xsdNode) *goType {
+        if t, ok := g.types[goName]; ok {
+                return t
+        }
+        t := &goType{Name: goName, Source: ct.attr("name")}
+        // registered before walking so recursive types terminate
+        g.types[goName] = t
+        t.Fields = g.fields(goName, ct, false)
+        dedupeFields(t.Fields)
+        return t
+}
+
+// Function to collect the fields of a complex type, sequence, choice or extension in schema order
+func (g *generator) fields(parent string, n *xsdNode, optional bool) []goField {
+        var fields []goField
+        for i := range n.Children {
+                c := &n.Children[i]
+                switch c.XMLName.Local {
+                case "sequence", "all":
+                        fields = append(fields, g.fields(parent, c, optional || c.attr("minOccurs") == "0")...)
+                case "choice":
+                        // every branch of a choice is optional; the caller sends the one it needs
+                        fields = append(fields, g.fields(parent, c, true)...)
+                case "complexContent":
+                        fields = append(fields, g.fields(parent, c, optional)...)
+                case "extension":
+                        if base, ok := g.complexTypes[localName(c.attr("base"))]; ok {
+                                fields = append(fields, g.fields(parent, base, optional)...)
+                        }
+                        fields = append(fields, g.fields(parent, c, optional)...)
+                case "restriction":
+                        fields = append(fields, g.fields(parent, c, optional)...)
+                case "element":
+                        fields = append(fields, g.elementField(parent, c, optional))
+                case "attribute":
+                        if name := c.attr("name"); name != "" {
+                                typ, _ := g.scalar(c.attr("type"))
+                                if c.attr("use") != "required" && typ != "string" {
+                                        typ = "*" + typ
+                                }
+                                fields = append(fields, goField{
+                                        Name: exported(name),
+                                        Type: typ,
+                                        Tag:  fmt.Sprintf("`json:\"%s,omitempty\" xml:\"%s,attr,omitempty\"`", name, name),
+                                })
+                        }
+                }
+        }
+        return fields
+}
+
+// Function to turn an element declaration into a field
+func (g *generator) elementField(parent string, el *xsdNode, optional bool) goField {
+        name := el.attr("name")
+        if ref := localName(el.attr("ref")); ref != "" {
+                name = ref
+                if top, ok := g.elements[ref]; ok {
+                        el = &xsdNode{XMLName: el.XMLName, Attrs: append(append([]xml.Attr(nil), top.Attrs...), el.Attrs...), Children: top.Children}
+                }
+        }
+        optional = optional || el.attr("minOccurs") == "0" || el.attr("nillable") == "true"
+        max := el.attr("maxOccurs")
+        repeated := max == "unbounded"
+        if n, err := strconv.Atoi(max); err == nil && n > 1 {
+                repeated = true
+        }
+
+        var typ string
+        isStruct := false
+        if inline := el.child("complexType"); inline != nil {
+                typ = g.structType(parent+exported(name), inline).Name
+                isStruct = true
+        } else if inline := el.child("simpleType"); inline != nil {
+                typ, _ = g.scalarNode(inline)
+        } else if t, ok := g.scalar(el.attr("type")); ok {
+                typ = t
+        } else if ct, ok := g.complexTypes[localName(el.attr("type"))]; ok {
+                typ = g.structType(exported(localName(el.attr("type"))), ct).Name
+                isStruct = true
+        } else {
+                typ = "string"
+        }
+
+        omit := ""
+        switch {
+        case repeated:
+                typ = "[]" + typ
+                omit = ",omitempty"
+        case optional && (isStruct || typ != "string"):
+                typ = "*" + typ
+                omit = ",omitempty"
+        case optional:
+                omit = ",omitempty"
+        }
+
+        return goField{
+                Name: exported(name),
+                Type: typ,
+                Tag:  fmt.Sprintf("`json:\"%s%s\" xml:\"%s%s\"`", name, omit, name, omit),
+        }
+}
+
+// Function to resolve a type name to a Go scalar; ok is false for complex types with element content
+func (g *generator) scalar(qname string) (string, bool) {
+        name := localName(qname)
+        if name == "" {
+                return "string", true
+        }
+        if prefix := strings.SplitN(qname, ":", 2)[0]; prefix == "xsd" || prefix == "xs" {
+                return builtinScalar(name), true
+        }
+        // AXL's own boolean accepts t/f/true/false, all of which strconv.ParseBool understands
+        if name == "boolean" {
+                return "bool", true
+        }
+        if st, ok := g.simpleTypes[name]; ok {
+                return g.scalarNode(st)
+        }
+        if ct, ok := g.complexTypes[name]; ok {
+                // simple content (e.g. XFkType: a name with a uuid attribute) is flattened to its value
+                if sc := ct.child("simpleContent"); sc != nil {
+                        for _, c := range sc.Children {
+                                if base := c.attr("base"); base != "" {
+                                        return g.scalar(base)
+                                }
+                        }
+                        return "string", true
+                }
+                return "", false
+        }
+        return "string", true
+}
+
+// Function to resolve an inline or named simpleType to a Go scalar
+func (g *generator) scalarNode(st *xsdNode) (string, bool) {
+        if r := st.child("restriction"); r != nil {
+                if base := r.attr("base"); base != "" {
+                        return g.scalar(base)
+                }
+        }
+        // unions and lists are sent as text
+        return "string", true
+}
+
+// Function to write every generated type, sorted by name, as gofmt'd Go source
+func (g *generator) render(pkg, version string) ([]byte, error) {
+        var buf bytes.Buffer
+        fmt.Fprintf(&buf, "// Code generated by axlgen from AXLSoap.xsd (AXL %s). DO NOT EDIT.\n\n", version)
+        fmt.Fprintf(&buf, "package %s\n\nimport \"encoding/xml\"\n\n", pkg)
+        fmt.Fprintf(&buf, "// Version is the AXL schema version these types were generated from\nconst Version = %q\n\n", version)
+        fmt.Fprintf(&buf, "// Namespace is the XML namespace bound to the axl prefix for this version\nconst Namespace = %q\n\n", "http://www.cisco.com/AXL/API/"+version)
+
+        for _, name := range sortedKeys(g.types) {
+                t := g.types[name]
+                source := "axlapi:" + t.Source
+                if t.Source == "" {
+                        source = "an inline type"
+                }
+                fmt.Fprintf(&buf, "\n// %s is generated from %s\n", t.Name, source)
+                if t.Shared {
+                        fmt.Fprintf(&buf, "// It is returned by several operations; wrap it to unmarshal a specific response.\n")
+                }
+                fmt.Fprintf(&buf, "type %s struct {\n", t.Name)
+                if t.XMLName != "" {
+                        fmt.Fprintf(&buf, "XMLName xml.Name `xml:\"%s\"`\n", t.XMLName)
+                }
+                for _, f := range t.Fields {
+                        fmt.Fprintf(&buf, "%s %s %s\n", f.Name, f.Type, f.Tag)
+                }
+                fmt.Fprintf(&buf, "}\n")
+        }
+
+        src, err := format.Source(buf.Bytes())
+        if err != nil {
+                return nil, fmt.Errorf("generated code does not compile: %v", err)
+        }
+        return src, nil
+}
+
+/****
+*
+* Helper functions
+*
+*/
+
+// Function to read an attribute of a schema node
+func (n *xsdNode) attr(name string) string {
+        for _, a := range n.Attrs {
+                if a.Name.Local == name {
+                        return a.Value
+                }
+        }
+        return ""
+}
+
+// Function to find the first child of a schema node with the given local name
+func (n *xsdNode) child(name string) *xsdNode {
+        for i := range n.Children {
+                if n.Children[i].XMLName.Local == name {
+                        return &n.Children[i]
+                }
+        }
+        return nil
+}
+
+// Function to map an XML Schema built-in type to a Go type
+func builtinScalar(name string) string {
+        switch name {
+        case "boolean":
+                return "bool"
+        case "int", "integer", "long", "short", "byte", "unsignedInt", "unsignedLong", "unsignedShort",
+                "nonNegativeInteger", "positiveInteger", "negativeInteger", "nonPositiveInteger":
+                return "int"
+        }
+        return "string"
+}
+
+// Function to strip the namespace prefix from a qualified name
+func localName(qname string) string {
+        if i := strings.LastIndex(qname, ":"); i >= 0 {
+                return qname[i+1:]
+        }
+        return qname
+}
+
+// Function to turn a schema name into an exported Go identifier
+func exported(name string) string {
+        name = nonIdentChars.ReplaceAllString(name, "_")
+        if name == "" {
+                return "X"
+        }
+        r := []rune(name)
+        if !unicode.IsLetter(r[0]) {
+                return "X" + name
+        }
+        r[0] = unicode.ToUpper(r[0])
+        return string(r)
+}
+
+// Function to rename fields that collide, e.g. a uuid attribute and a uuid element
+func dedupeFields(fields []goField) {
+        seen := make(map[string]bool)
+        for i := range fields {
+                for seen[fields[i].Name] {
+                        fields[i].Name += "_"
+                }
+                seen[fields[i].Name] = true
+        }
+}
+
+// Function to list map keys in sorted order so the output is stable
+func sortedKeys[V any](m map[string]V) []string {
+        keys := make([]string, 0, len(m))
+        for k := range m {
+                keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        return keys
+}
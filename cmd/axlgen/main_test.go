@@ -0,0 +1,94 @@
+package main
+
+import (
+        "go/ast"
+        "go/importer"
+        "go/parser"
+        "go/token"
+        "go/types"
+        "regexp"
+        "testing"
+)
+
+func TestGenerate(t *testing.T) {
+        g := newGenerator()
+        if _, err := g.generate("testdata", regexp.MustCompile(`^(add|get|update|list|remove)[A-Z]`)); err != nil {
+                t.Fatalf("generate: %v", err)
+        }
+        src, err := g.render("axl", "14.0")
+        if err != nil {
+                t.Fatalf("render: %v", err)
+        }
+
+        // the generated file must type-check as a package of its own, not just parse
+        fset := token.NewFileSet()
+        file, err := parser.ParseFile(fset, "axl_gen.go", src, 0)
+        if err != nil {
+                t.Fatalf("parse: %v\n%s", err, src)
+        }
+        conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+        pkg, err := conf.Check("axl", fset, []*ast.File{file}, nil)
+        if err != nil {
+                t.Fatalf("generated code does not compile: %v\n%s", err, src)
+        }
+
+        tests := []struct {
+                name  string
+                typ   string
+                field string
+                want  string
+                tag   string
+        }{
+                {name: "request element", typ: "AddPhone", field: "XMLName", want: "encoding/xml.Name", tag: `xml:"axl:addPhone"`},
+                {name: "response element", typ: "GetPhoneResponse", field: "XMLName", want: "encoding/xml.Name", tag: `xml:"getPhoneResponse"`},
+                {name: "named complex type", typ: "AddPhone", field: "Phone", want: "XPhone", tag: `json:"phone" xml:"phone"`},
+                {name: "inline complex type", typ: "GetPhoneResponse", field: "Return", want: "GetPhoneResponseReturn", tag: `json:"return" xml:"return"`},
+                {name: "required string", typ: "XPhone", field: "Name", want: "string", tag: `json:"name" xml:"name"`},
+                {name: "optional string", typ: "XPhone", field: "Description", want: "string", tag: `json:"description,omitempty" xml:"description,omitempty"`},
+                {name: "enum from an included schema", typ: "XPhone", field: "Protocol", want: "string", tag: `json:"protocol" xml:"protocol"`},
+                {name: "optional integer", typ: "XPhone", field: "MaxNumCalls", want: "*int", tag: `json:"maxNumCalls,omitempty" xml:"maxNumCalls,omitempty"`},
+                {name: "optional AXL boolean", typ: "XPhone", field: "EnableExtensionMobility", want: "*bool", tag: `json:"enableExtensionMobility,omitempty" xml:"enableExtensionMobility,omitempty"`},
+                {name: "name reference", typ: "XPhone", field: "DevicePoolName", want: "string", tag: `json:"devicePoolName" xml:"devicePoolName"`},
+                {name: "optional inline struct", typ: "XPhone", field: "Lines", want: "*XPhoneLines", tag: `json:"lines,omitempty" xml:"lines,omitempty"`},
+                {name: "attribute", typ: "XPhone", field: "Ctiid", want: "*int", tag: `json:"ctiid,omitempty" xml:"ctiid,attr,omitempty"`},
+                {name: "repeated element", typ: "XPhoneLines", field: "Line", want: "[]XPhoneLine", tag: `json:"line,omitempty" xml:"line,omitempty"`},
+                {name: "choice branch", typ: "NameAndGUIDRequest", field: "Uuid", want: "string", tag: `json:"uuid,omitempty" xml:"uuid,omitempty"`},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        obj := pkg.Scope().Lookup(tt.typ)
+                        if obj == nil {
+                                t.Fatalf("type %s was not generated", tt.typ)
+                        }
+                        st, ok := obj.Type().Underlying().(*types.Struct)
+                        if !ok {
+                                t.Fatalf("%s is not a struct", tt.typ)
+                        }
+                        for i := 0; i < st.NumFields(); i++ {
+                                if st.Field(i).Name() != tt.field {
+                                        continue
+                                }
+                                if got := types.TypeString(st.Field(i).Type(), types.RelativeTo(pkg)); got != tt.want {
+                                        t.Errorf("%s.%s has type %s, want %s", tt.typ, tt.field, got, tt.want)
+                                }
+                                if got := st.Tag(i); got != tt.tag {
+                                        t.Errorf("%s.%s has tag %s, want %s", tt.typ, tt.field, got, tt.tag)
+                                }
+                                return
+                        }
+                        t.Errorf("%s has no field %s", tt.typ, tt.field)
+                })
+        }
+
+        // operations come from the WSDL port: listPhone is only in the schema and doDeviceReset does not match the pattern
+        for _, name := range []string{"ListPhone", "DoDeviceReset", "DoDeviceResetResponse"} {
+                if pkg.Scope().Lookup(name) != nil {
+                        t.Errorf("type %s was generated, but is not a matching operation of the WSDL", name)
+                }
+        }
+        // StandardResponse backs two responses, so it cannot carry either element name
+        if st := pkg.Scope().Lookup("StandardResponse").Type().Underlying().(*types.Struct); st.Field(0).Name() == "XMLName" {
+                t.Errorf("shared StandardResponse has an XMLName")
+        }
+}
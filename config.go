@@ -0,0 +1,669 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "bytes"
+        "crypto/x509"
+        "errors"
+        "fmt"
+        "net"
+        "os"
+        "path/filepath"
+        "regexp"
+        "strconv"
+        "strings"
+        "time"
+
+        "github.com/BurntSushi/toml"
+        "gopkg.in/yaml.v3"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// Config holds the runtime configuration for cm-gator
+type Config struct {
+        Listen         ListenConfig    `yaml:"listen" toml:"listen"`
+        AXL            AXLConfig       `yaml:"axl" toml:"axl"`
+        Clusters       []ClusterConfig `yaml:"clusters" toml:"clusters"`
+        DefaultCluster string          `yaml:"defaultCluster" toml:"defaultCluster"`
+        DNRanges       []DNRange       `yaml:"dnRanges" toml:"dnRanges"`
+        Reports        ReportConfig    `yaml:"reports" toml:"reports"`
+        SQLUpdate      SQLUpdateConfig `yaml:"sqlUpdate" toml:"sqlUpdate"`
+        Trace          TraceConfig     `yaml:"trace" toml:"trace"`
+
+        // clusters is built by validate from Clusters (or from AXL alone), keyed by name
+        clusters     map[string]*Cluster
+        clusterOrder []string
+}
+
+// ListenConfig describes the REST listener
+type ListenConfig struct {
+        Addr     string `yaml:"addr" toml:"addr"`
+        CertFile string `yaml:"certFile" toml:"certFile"`
+        KeyFile  string `yaml:"keyFile" toml:"keyFile"`
+}
+
+// AXLConfig describes the CUCM publisher that AXL requests are sent to
+type AXLConfig struct {
+        // Host is the publisher; writes only go there, reads fail over to Subscribers ("host" or "host:port")
+        Host        string   `yaml:"host" toml:"host"`
+        Subscribers []string `yaml:"subscribers" toml:"subscribers"`
+        Port        int      `yaml:"port" toml:"port"`
+        Version     string   `yaml:"version" toml:"version"`
+        // UnsupportedFields is "reject" or "drop": what to do with fields newer than the cluster's AXL version
+        UnsupportedFields string    `yaml:"unsupportedFields" toml:"unsupportedFields"`
+        Username          string    `yaml:"username" toml:"username"`
+        Password          string    `yaml:"password" toml:"password"`
+        TLS               TLSConfig `yaml:"tls" toml:"tls"`
+        // MaxConcurrent caps the AXL requests cm-gator has in flight to the cluster at once
+        MaxConcurrent int `yaml:"maxConcurrent" toml:"maxConcurrent"`
+        // HealthRetry is how long a node that could not be reached is skipped before it is tried again
+        HealthRetry time.Duration `yaml:"healthRetry" toml:"healthRetry"`
+        Timeouts    TimeoutConfig `yaml:"timeouts" toml:"timeouts"`
+        Retry       RetryConfig   `yaml:"retry" toml:"retry"`
+}
+
+// RetryConfig sets how throttled AXL requests are retried
+type RetryConfig struct {
+        // Attempts is the most times a request is sent, counting the first
+        Attempts  int           `yaml:"attempts" toml:"attempts"`
+        BaseDelay time.Duration `yaml:"baseDelay" toml:"baseDelay"`
+        MaxDelay  time.Duration `yaml:"maxDelay" toml:"maxDelay"`
+}
+
+// TimeoutConfig bounds each stage of an AXL request
+type TimeoutConfig struct {
+        // Connect covers the TCP connection and the TLS handshake to one node
+        Connect time.Duration `yaml:"connect" toml:"connect"`
+        // Response is the wait for CUCM to start answering once the request is sent
+        Response time.Duration `yaml:"response" toml:"response"`
+        // Request is the limit for the whole request, including reading the answer
+        Request time.Duration `yaml:"request" toml:"request"`
+        // Idle is how long a pooled keep-alive connection is kept open unused
+        Idle time.Duration `yaml:"idle" toml:"idle"`
+}
+
+// ClusterConfig is one named CUCM cluster; unset fields are inherited from the axl section
+type ClusterConfig struct {
+        Name      string `yaml:"name" toml:"name"`
+        AXLConfig `yaml:",inline"`
+}
+
+// TLSConfig describes how the AXL server certificate is trusted
+type TLSConfig struct {
+        InsecureSkipVerify bool   `yaml:"insecureSkipVerify" toml:"insecureSkipVerify"`
+        CAFile             string `yaml:"caFile" toml:"caFile"`
+        // Pins maps a node host to the certificates it may present, as "sha256/<base64 SPKI hash>" or a
+        // hex SHA-256 certificate fingerprint; a pinned node is checked against its pins instead of caFile
+        Pins map[string][]string `yaml:"pins" toml:"pins"`
+        // PinsFile holds the pins written by "cm-gator trust"; they are added to Pins
+        PinsFile string `yaml:"pinsFile" toml:"pinsFile"`
+
+        rootCAs *x509.CertPool
+        pins    map[string][]certPin
+}
+
+// DNRange is a block of directory numbers that "auto:<name>" patterns are allocated from
+type DNRange struct {
+        Name string `yaml:"name" toml:"name"`
+        // Cluster limits the range to one cluster; empty means it applies to every cluster
+        Cluster   string   `yaml:"cluster" toml:"cluster"`
+        Start     string   `yaml:"start" toml:"start"`
+        End       string   `yaml:"end" toml:"end"`
+        Partition string   `yaml:"partition" toml:"partition"`
+        Reserved  []string `yaml:"reserved" toml:"reserved"`
+}
+
+// ReportConfig holds settings for the /reports endpoints and the report command
+type ReportConfig struct {
+        // LocationPattern splits a line description into named groups location, firstName and lastName
+        LocationPattern string `yaml:"locationPattern" toml:"locationPattern"`
+
+        locationRegexp *regexp.Regexp
+}
+
+// SQLUpdateConfig guards the /sql/update endpoint; it is off unless enabled
+type SQLUpdateConfig struct {
+        Enabled  bool          `yaml:"enabled" toml:"enabled"`
+        Tables   []string      `yaml:"tables" toml:"tables"`
+        MaxRows  int           `yaml:"maxRows" toml:"maxRows"`
+        TokenTTL time.Duration `yaml:"tokenTTL" toml:"tokenTTL"`
+        AuditLog string        `yaml:"auditLog" toml:"auditLog"`
+        // Secret signs confirmation tokens; a random one is used when empty, so tokens do not survive a restart
+        Secret string `yaml:"secret" toml:"secret"`
+}
+
+// TraceConfig controls what is recorded about AXL traffic
+type TraceConfig struct {
+        // Enabled logs one line per AXL request with its operation, node, duration, status and fault code
+        Enabled bool `yaml:"enabled" toml:"enabled"`
+        // Redact lists the elements, and the columns in SQL text, whose values are masked in every envelope that is written out
+        Redact []string `yaml:"redact" toml:"redact"`
+        // DebugFile, when set, receives every request and response envelope, redacted
+        DebugFile string `yaml:"debugFile" toml:"debugFile"`
+
+        redactRegexp    *regexp.Regexp
+        redactSQLRegexp *regexp.Regexp
+}
+
+// Default location of the config file when -config and CMGATOR_CONFIG are unset
+const defaultConfigFile = "cm-gator.yaml"
+
+var axlVersionPattern = regexp.MustCompile(`^\d+\.\d+$`)
+
+var digitsPattern = regexp.MustCompile(`^\d+$`)
+
+var clusterNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Active configuration, set once in main()
+var config *Config
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to build a config with the values main() used to hard-code
+func defaultConfig() *Config {
+        return &Config{
+                Listen: ListenConfig{
+                        Addr:     ":8443",
+                        CertFile: "./server.crt",
+                        KeyFile:  "./server.key",
+                },
+                AXL: AXLConfig{
+                        Port:              8443,
+                        Version:           axlVersionAuto,
+                        UnsupportedFields: "reject",
+                        MaxConcurrent:     4,
+                        HealthRetry:       30 * time.Second,
+                        Timeouts: TimeoutConfig{
+                                Connect:  10 * time.Second,
+                                Response: 60 * time.Second,
+                                Request:  5 * time.Minute,
+                                Idle:     90 * time.Second,
+                        },
+                        Retry: RetryConfig{
+                                Attempts:  4,
+                                BaseDelay: time.Second,
+                                MaxDelay:  30 * time.Second,
+                        },
+                        TLS: TLSConfig{
+                                PinsFile: "./axl-pins.json",
+                        },
+                },
+                SQLUpdate: SQLUpdateConfig{
+                        MaxRows:  100,
+                        TokenTTL: 10 * time.Minute,
+                        AuditLog: "./sql-audit.log",
+                },
+                Trace: TraceConfig{
+                        Enabled: true,
+                        Redact:  []string{"password", "pin", "digestUser", "digestCredentials", "sshPassword", "authenticationString"},
+                },
+                Reports: ReportConfig{
+                        // the "Location - First Last - ..." convention used by cm-gator.py
+                        LocationPattern: `^(?P<location>[A-Za-z ]+) - (?:(?P<firstName>[A-Za-z]+) (?P<lastName>[A-Za-z]+) - )?`,
+                },
+        }
+}
+
+// Function to load the config file (if any), apply environment overrides and validate;
+// the listener settings are only checked when serve is set
+func loadConfig(path string, serve bool) (*Config, error) {
+        cfg := defaultConfig()
+
+        explicit := path != ""
+        if !explicit {
+                path = os.Getenv("CMGATOR_CONFIG")
+                explicit = path != ""
+        }
+        if !explicit {
+                path = defaultConfigFile
+        }
+
+        if err := cfg.readFile(path); err != nil {
+                if explicit || !errors.Is(err, os.ErrNotExist) {
+                        return nil, err
+                }
+        }
+
+        if err := cfg.applyEnv(); err != nil {
+                return nil, err
+        }
+
+        if err := cfg.validate(serve); err != nil {
+                return nil, err
+        }
+
+        return cfg, nil
+}
+
+// Function to decode a YAML or TOML config file, chosen by extension
+func (c *Config) readFile(path string) error {
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return fmt.Errorf("failed to read config file: %w", err)
+        }
+
+        switch strings.ToLower(filepath.Ext(path)) {
+        case ".yaml", ".yml":
+                dec := yaml.NewDecoder(bytes.NewReader(data))
+                dec.KnownFields(true)
+                if err := dec.Decode(c); err != nil {
+                        return fmt.Errorf("failed to parse config file %s: %v", path, err)
+                }
+        case ".toml":
+                md, err := toml.Decode(string(data), c)
+                if err != nil {
+                        return fmt.Errorf("failed to parse config file %s: %v", path, err)
+                }
+                if undecoded := md.Undecoded(); len(undecoded) > 0 {
+                        return fmt.Errorf("failed to parse config file %s: unknown keys %v", path, undecoded)
+                }
+        default:
+                return fmt.Errorf("unsupported config file type %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+        }
+
+        return nil
+}
+
+// Function to override config values from CMGATOR_* environment variables
+func (c *Config) applyEnv() error {
+        strVars := map[string]*string{
+                "CMGATOR_LISTEN_ADDR":             &c.Listen.Addr,
+                "CMGATOR_CERT_FILE":               &c.Listen.CertFile,
+                "CMGATOR_KEY_FILE":                &c.Listen.KeyFile,
+                "CMGATOR_AXL_HOST":                &c.AXL.Host,
+                "CMGATOR_AXL_VERSION":             &c.AXL.Version,
+                "CMGATOR_AXL_UNSUPPORTED_FIELDS":  &c.AXL.UnsupportedFields,
+                "CMGATOR_AXL_USERNAME":            &c.AXL.Username,
+                "CMGATOR_AXL_PASSWORD":            &c.AXL.Password,
+                "CMGATOR_AXL_TLS_CA_FILE":         &c.AXL.TLS.CAFile,
+                "CMGATOR_AXL_TLS_PINS_FILE":       &c.AXL.TLS.PinsFile,
+                "CMGATOR_REPORT_LOCATION_PATTERN": &c.Reports.LocationPattern,
+                "CMGATOR_SQL_UPDATE_SECRET":       &c.SQLUpdate.Secret,
+                "CMGATOR_DEFAULT_CLUSTER":         &c.DefaultCluster,
+                "CMGATOR_TRACE_DEBUG_FILE":        &c.Trace.DebugFile,
+        }
+        for name, dst := range strVars {
+                if v, ok := os.LookupEnv(name); ok {
+                        *dst = v
+                }
+        }
+
+        // per-cluster credentials, e.g. CMGATOR_CLUSTER_EMEA_PASSWORD for the cluster named "emea"
+        for i := range c.Clusters {
+                prefix := "CMGATOR_CLUSTER_" + strings.ToUpper(strings.ReplaceAll(c.Clusters[i].Name, "-", "_")) + "_"
+                if v, ok := os.LookupEnv(prefix + "USERNAME"); ok {
+                        c.Clusters[i].Username = v
+                }
+                if v, ok := os.LookupEnv(prefix + "PASSWORD"); ok {
+                        c.Clusters[i].Password = v
+                }
+        }
+
+        if v, ok := os.LookupEnv("CMGATOR_AXL_SUBSCRIBERS"); ok {
+                c.AXL.Subscribers = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+        }
+
+        if v, ok := os.LookupEnv("CMGATOR_AXL_PORT"); ok {
+                port, err := strconv.Atoi(v)
+                if err != nil {
+                        return fmt.Errorf("CMGATOR_AXL_PORT: %q is not a number", v)
+                }
+                c.AXL.Port = port
+        }
+
+        if v, ok := os.LookupEnv("CMGATOR_AXL_TLS_INSECURE"); ok {
+                insecure, err := strconv.ParseBool(v)
+                if err != nil {
+                        return fmt.Errorf("CMGATOR_AXL_TLS_INSECURE: %q is not a boolean", v)
+                }
+                c.AXL.TLS.InsecureSkipVerify = insecure
+        }
+
+        if v, ok := os.LookupEnv("CMGATOR_TRACE"); ok {
+                enabled, err := strconv.ParseBool(v)
+                if err != nil {
+                        return fmt.Errorf("CMGATOR_TRACE: %q is not a boolean", v)
+                }
+                c.Trace.Enabled = enabled
+        }
+
+        return nil
+}
+
+// Function to check the config and report every problem at once
+func (c *Config) validate(serve bool) error {
+        var errs []error
+
+        if serve {
+                if c.Listen.Addr == "" {
+                        errs = append(errs, errors.New("listen.addr is required (CMGATOR_LISTEN_ADDR)"))
+                } else if _, _, err := net.SplitHostPort(c.Listen.Addr); err != nil {
+                        errs = append(errs, fmt.Errorf("listen.addr %q is not a valid host:port", c.Listen.Addr))
+                }
+                errs = append(errs, checkFile("listen.certFile", c.Listen.CertFile)...)
+                errs = append(errs, checkFile("listen.keyFile", c.Listen.KeyFile)...)
+        }
+
+        errs = append(errs, c.buildClusters()...)
+
+        if re, err := regexp.Compile(c.Reports.LocationPattern); err != nil {
+                errs = append(errs, fmt.Errorf("reports.locationPattern: %v", err))
+        } else if re.SubexpIndex("location") < 0 {
+                errs = append(errs, errors.New("reports.locationPattern needs a (?P<location>...) group"))
+        } else {
+                c.Reports.locationRegexp = re
+        }
+
+        if elements, sql, err := compileRedactPattern(c.Trace.Redact); err != nil {
+                errs = append(errs, fmt.Errorf("trace.redact: %v", err))
+        } else {
+                c.Trace.redactRegexp, c.Trace.redactSQLRegexp = elements, sql
+        }
+
+        if c.SQLUpdate.Enabled {
+                for i, table := range c.SQLUpdate.Tables {
+                        c.SQLUpdate.Tables[i] = strings.ToLower(table)
+                }
+                if len(c.SQLUpdate.Tables) == 0 {
+                        errs = append(errs, errors.New("sqlUpdate.tables must list the tables that may be changed"))
+                }
+                if c.SQLUpdate.MaxRows < 1 {
+                        errs = append(errs, fmt.Errorf("sqlUpdate.maxRows %d must be at least 1", c.SQLUpdate.MaxRows))
+                }
+                if c.SQLUpdate.TokenTTL <= 0 {
+                        errs = append(errs, errors.New("sqlUpdate.tokenTTL must be positive"))
+                }
+                if c.SQLUpdate.AuditLog == "" {
+                        errs = append(errs, errors.New("sqlUpdate.auditLog is required"))
+                }
+        }
+
+        names := make(map[string]bool)
+        for i, dr := range c.DNRanges {
+                key := fmt.Sprintf("dnRanges[%d]", i)
+                if dr.Name == "" {
+                        errs = append(errs, fmt.Errorf("%s.name is required", key))
+                } else if names[dr.Cluster+"/"+dr.Name] {
+                        errs = append(errs, fmt.Errorf("%s.name %q is used twice", key, dr.Name))
+                }
+                names[dr.Cluster+"/"+dr.Name] = true
+                if _, ok := c.clusters[dr.Cluster]; dr.Cluster != "" && !ok && c.clusters != nil {
+                        errs = append(errs, fmt.Errorf("%s.cluster %q is not a configured cluster", key, dr.Cluster))
+                }
+                if _, _, err := dr.bounds(); err != nil {
+                        errs = append(errs, fmt.Errorf("%s: %v", key, err))
+                }
+                for _, r := range dr.Reserved {
+                        if _, _, err := dr.span(r); err != nil {
+                                errs = append(errs, fmt.Errorf("%s.reserved: %v", key, err))
+                        }
+                }
+        }
+
+        if len(errs) > 0 {
+                return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+        }
+        return nil
+}
+
+// Function to build the runtime clusters: the axl section alone becomes cluster "default",
+// otherwise every clusters entry inherits the axl values it leaves unset
+func (c *Config) buildClusters() []error {
+        var errs []error
+        entries := c.Clusters
+        if len(entries) == 0 {
+                entries = []ClusterConfig{{Name: "default", AXLConfig: c.AXL}}
+        } else if c.AXL.Host != "" {
+                errs = append(errs, errors.New("axl.host cannot be combined with clusters; add the host as a cluster"))
+        }
+
+        c.clusters = make(map[string]*Cluster)
+        c.clusterOrder = nil
+        for i, entry := range entries {
+                key := "axl"
+                if len(c.Clusters) > 0 {
+                        key = fmt.Sprintf("clusters[%d]", i)
+                        entry.AXLConfig.inherit(&c.AXL)
+                }
+
+                switch {
+                case !clusterNamePattern.MatchString(entry.Name):
+                        errs = append(errs, fmt.Errorf("%s.name %q must be letters, digits, - or _", key, entry.Name))
+                case entry.Name == clusterAll:
+                        errs = append(errs, fmt.Errorf("%s.name %q is reserved", key, entry.Name))
+                case c.clusters[entry.Name] != nil:
+                        errs = append(errs, fmt.Errorf("%s.name %q is used twice", key, entry.Name))
+                }
+                errs = append(errs, entry.AXLConfig.validate(key)...)
+
+                c.clusters[entry.Name] = newCluster(entry.Name, entry.AXLConfig)
+                c.clusterOrder = append(c.clusterOrder, entry.Name)
+        }
+
+        if c.DefaultCluster == "" && len(c.clusterOrder) > 0 {
+                c.DefaultCluster = c.clusterOrder[0]
+        }
+        if c.clusters[c.DefaultCluster] == nil {
+                errs = append(errs, fmt.Errorf("defaultCluster %q is not a configured cluster", c.DefaultCluster))
+        }
+        return errs
+}
+
+// Function to fill the settings a cluster leaves unset from the axl section
+func (a *AXLConfig) inherit(base *AXLConfig) {
+        if a.Port == 0 {
+                a.Port = base.Port
+        }
+        if a.Version == "" {
+                a.Version = base.Version
+        }
+        if a.Username == "" {
+                a.Username = base.Username
+        }
+        if a.Password == "" {
+                a.Password = base.Password
+        }
+        if a.UnsupportedFields == "" {
+                a.UnsupportedFields = base.UnsupportedFields
+        }
+        if a.MaxConcurrent == 0 {
+                a.MaxConcurrent = base.MaxConcurrent
+        }
+        if a.HealthRetry == 0 {
+                a.HealthRetry = base.HealthRetry
+        }
+        if a.Timeouts == (TimeoutConfig{}) {
+                a.Timeouts = base.Timeouts
+        }
+        if a.Retry == (RetryConfig{}) {
+                a.Retry = base.Retry
+        }
+        // insecureSkipVerify in the axl section applies to every cluster; pins are merged per host
+        a.TLS.InsecureSkipVerify = a.TLS.InsecureSkipVerify || base.TLS.InsecureSkipVerify
+        if a.TLS.CAFile == "" {
+                a.TLS.CAFile = base.TLS.CAFile
+        }
+        if a.TLS.PinsFile == "" {
+                a.TLS.PinsFile = base.TLS.PinsFile
+        }
+        for host, pins := range base.TLS.Pins {
+                if _, ok := a.TLS.Pins[host]; !ok {
+                        if a.TLS.Pins == nil {
+                                a.TLS.Pins = make(map[string][]string)
+                        }
+                        a.TLS.Pins[host] = pins
+                }
+        }
+}
+
+// Function to check one cluster's AXL settings; key is "axl" or "clusters[N]"
+func (a *AXLConfig) validate(key string) []error {
+        var errs []error
+
+        // the environment variable only applies to the single-cluster axl section
+        hint := func(env string) string {
+                if key == "axl" {
+                        return " (" + env + ")"
+                }
+                return ""
+        }
+
+        if a.Host == "" {
+                errs = append(errs, fmt.Errorf("%s.host is required%s", key, hint("CMGATOR_AXL_HOST")))
+        }
+        if a.Port < 1 || a.Port > 65535 {
+                errs = append(errs, fmt.Errorf("%s.port %d is out of range 1-65535", key, a.Port))
+        }
+        if a.Version != axlVersionAuto && !axlVersionPattern.MatchString(a.Version) {
+                errs = append(errs, fmt.Errorf("%s.version %q must be %q or look like 14.0", key, a.Version, axlVersionAuto))
+        }
+        if a.UnsupportedFields != "reject" && a.UnsupportedFields != "drop" {
+                errs = append(errs, fmt.Errorf("%s.unsupportedFields %q must be reject or drop", key, a.UnsupportedFields))
+        }
+        for i, sub := range a.Subscribers {
+                if _, _, err := splitNodeAddr(sub, a.Port); err != nil {
+                        errs = append(errs, fmt.Errorf("%s.subscribers[%d]: %v", key, i, err))
+                }
+        }
+        if a.MaxConcurrent < 1 {
+                errs = append(errs, fmt.Errorf("%s.maxConcurrent %d must be at least 1", key, a.MaxConcurrent))
+        }
+        if a.HealthRetry <= 0 {
+                errs = append(errs, fmt.Errorf("%s.healthRetry must be positive", key))
+        }
+        if a.Retry.Attempts < 1 {
+                errs = append(errs, fmt.Errorf("%s.retry.attempts %d must be at least 1", key, a.Retry.Attempts))
+        }
+        if a.Retry.BaseDelay <= 0 || a.Retry.MaxDelay < a.Retry.BaseDelay {
+                errs = append(errs, fmt.Errorf("%s.retry.baseDelay must be positive and not above retry.maxDelay", key))
+        }
+        timeouts := []struct {
+                name string
+                d    time.Duration
+        }{
+                {"connect", a.Timeouts.Connect},
+                {"response", a.Timeouts.Response},
+                {"request", a.Timeouts.Request},
+                {"idle", a.Timeouts.Idle},
+        }
+        for _, t := range timeouts {
+                if t.d <= 0 {
+                        errs = append(errs, fmt.Errorf("%s.timeouts.%s must be positive", key, t.name))
+                }
+        }
+        if a.Username == "" {
+                errs = append(errs, fmt.Errorf("%s.username is required%s", key, hint("CMGATOR_AXL_USERNAME")))
+        }
+        if a.Password == "" {
+                errs = append(errs, fmt.Errorf("%s.password is required%s", key, hint("CMGATOR_AXL_PASSWORD")))
+        }
+
+        if a.TLS.CAFile != "" {
+                pem, err := os.ReadFile(a.TLS.CAFile)
+                if err != nil {
+                        errs = append(errs, fmt.Errorf("%s.tls.caFile: %v", key, err))
+                } else {
+                        pool := x509.NewCertPool()
+                        if !pool.AppendCertsFromPEM(pem) {
+                                errs = append(errs, fmt.Errorf("%s.tls.caFile %s contains no PEM certificates", key, a.TLS.CAFile))
+                        }
+                        a.TLS.rootCAs = pool
+                }
+        }
+
+        pins, err := a.TLS.loadPins()
+        if err != nil {
+                errs = append(errs, fmt.Errorf("%s.tls: %v", key, err))
+        }
+        a.TLS.pins = pins
+        return errs
+}
+
+// Function to check that a required file setting points at a readable file
+func checkFile(key, path string) []error {
+        if path == "" {
+                return []error{fmt.Errorf("%s is required", key)}
+        }
+        if _, err := os.Stat(path); err != nil {
+                return []error{fmt.Errorf("%s: %v", key, err)}
+        }
+        return nil
+}
+
+// Function to build the AXL endpoint URL
+func (a *AXLConfig) URL() string {
+        return fmt.Sprintf("https://%s/axl/", net.JoinHostPort(a.Host, strconv.Itoa(a.Port)))
+}
+
+// Function to split a subscriber entry into host and port; the port defaults to the cluster's
+func splitNodeAddr(addr string, defaultPort int) (string, int, error) {
+        host, portStr, err := net.SplitHostPort(addr)
+        if err != nil {
+                if addr == "" || strings.Contains(addr, "/") {
+                        return "", 0, fmt.Errorf("%q is not a host or host:port", addr)
+                }
+                return strings.Trim(addr, "[]"), defaultPort, nil
+        }
+        port, err := strconv.Atoi(portStr)
+        if err != nil || port < 1 || port > 65535 {
+                return "", 0, fmt.Errorf("%q has an invalid port", addr)
+        }
+        return host, port, nil
+}
+
+// Function to build the AXL XML namespace for the configured version
+func (a *AXLConfig) Namespace() string {
+        return "http://www.cisco.com/AXL/API/" + a.Version
+}
+
+// Function to build the SOAPAction header for the configured version
+func (a *AXLConfig) SOAPAction() string {
+        return "CUCM:DB ver=" + a.Version
+}
+
+// Function to parse the numeric bounds of a DN range; start and end must have the same number of digits
+func (d *DNRange) bounds() (start, end uint64, err error) {
+        if !digitsPattern.MatchString(d.Start) || !digitsPattern.MatchString(d.End) {
+                return 0, 0, fmt.Errorf("start %q and end %q must be digits", d.Start, d.End)
+        }
+        if len(d.Start) != len(d.End) {
+                return 0, 0, fmt.Errorf("start %q and end %q must have the same length", d.Start, d.End)
+        }
+        start, _ = strconv.ParseUint(d.Start, 10, 64)
+        end, _ = strconv.ParseUint(d.End, 10, 64)
+        if start > end {
+                return 0, 0, fmt.Errorf("start %q is after end %q", d.Start, d.End)
+        }
+        return start, end, nil
+}
+
+// Function to parse a reserved entry, either a single number ("4150") or a span ("4190-4199")
+func (d *DNRange) span(entry string) (from, to uint64, err error) {
+        lo, hi, found := strings.Cut(entry, "-")
+        if !found {
+                hi = lo
+        }
+        if !digitsPattern.MatchString(lo) || !digitsPattern.MatchString(hi) || len(lo) != len(d.Start) || len(hi) != len(d.Start) {
+                return 0, 0, fmt.Errorf("%q must be %d-digit numbers", entry, len(d.Start))
+        }
+        from, _ = strconv.ParseUint(lo, 10, 64)
+        to, _ = strconv.ParseUint(hi, 10, 64)
+        if from > to {
+                return 0, 0, fmt.Errorf("%q runs backwards", entry)
+        }
+        return from, to, nil
+}
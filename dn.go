@@ -0,0 +1,221 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// DNReservation is a number handed out by the allocator and held until it is released
+type DNReservation struct {
+        Cluster            string
+        Pattern            string
+        RoutePartitionName string
+}
+
+// dnAllocator hands out free numbers from the configured ranges; pending holds numbers
+// given to a request until it releases them, so concurrent calls never share one. There is no expiry,
+// since a request can run for as long as its timeouts and retries allow and always releases on return
+type dnAllocator struct {
+        mu      sync.Mutex
+        pending map[DNReservation]bool
+        // one lock per cluster and partition, held across the numplan query and by release, so a
+        // number is never created and released while a query that missed it is running
+        partitions map[string]*sync.Mutex
+}
+
+// Prefix that asks for a number from a configured range, e.g. "auto:hq"
+const autoPatternPrefix = "auto:"
+
+// Allocation failures that handlers can test for with errors.Is
+var (
+        ErrUnknownDNRange   = errors.New("unknown number range")
+        ErrDNRangeExhausted = errors.New("no free number in range")
+        ErrDNRangeMismatch  = errors.New("routePartitionName does not match the range")
+)
+
+var allocator = &dnAllocator{
+        pending:    make(map[DNReservation]bool),
+        partitions: make(map[string]*sync.Mutex),
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to find a configured range by name; a range naming the cluster wins over one without a cluster
+func findDNRange(cl *Cluster, name string) (*DNRange, error) {
+        var shared *DNRange
+        for i := range config.DNRanges {
+                dr := &config.DNRanges[i]
+                switch {
+                case dr.Name != name:
+                case dr.Cluster == cl.Name:
+                        return dr, nil
+                case dr.Cluster == "":
+                        shared = dr
+                }
+        }
+        if shared != nil {
+                return shared, nil
+        }
+        return nil, fmt.Errorf("%w %q on cluster %q", ErrUnknownDNRange, name, cl.Name)
+}
+
+// Function to reserve the lowest number in the range that is not in numplan, not reserved
+// in config and not already handed out; only the lock of the range's partition is held across
+// the numplan query, so allocations elsewhere do not wait for it
+func (a *dnAllocator) allocate(ctx context.Context, rangeName string) (DNReservation, error) {
+        cl := clusterFrom(ctx)
+        dr, err := findDNRange(cl, rangeName)
+        if err != nil {
+                return DNReservation{}, err
+        }
+        start, end, _ := dr.bounds()
+
+        lock := a.partitionLock(cl.Name, dr.Partition)
+        lock.Lock()
+        defer lock.Unlock()
+
+        used, err := numbersInUse(ctx, dr)
+        if err != nil {
+                return DNReservation{}, err
+        }
+        for _, entry := range dr.Reserved {
+                from, to, _ := dr.span(entry)
+                for n := from; n <= to; n++ {
+                        used[n] = true
+                }
+        }
+
+        a.mu.Lock()
+        defer a.mu.Unlock()
+
+        width := len(dr.Start)
+        for n := start; n <= end; n++ {
+                if used[n] {
+                        continue
+                }
+                res := DNReservation{
+                        Cluster:            cl.Name,
+                        Pattern:            fmt.Sprintf("%0*d", width, n),
+                        RoutePartitionName: dr.Partition,
+                }
+                if a.pending[res] {
+                        continue
+                }
+                a.pending[res] = true
+                return res, nil
+        }
+        return DNReservation{}, fmt.Errorf("%w %q", ErrDNRangeExhausted, rangeName)
+}
+
+// Function to drop a reservation once the request has either created the number or failed
+func (a *dnAllocator) release(res DNReservation) {
+        lock := a.partitionLock(res.Cluster, res.RoutePartitionName)
+        lock.Lock()
+        defer lock.Unlock()
+
+        a.mu.Lock()
+        defer a.mu.Unlock()
+        delete(a.pending, res)
+}
+
+// Function to find the lock of a partition on a cluster, creating it on first use
+func (a *dnAllocator) partitionLock(cluster, partition string) *sync.Mutex {
+        a.mu.Lock()
+        defer a.mu.Unlock()
+
+        key := cluster + "/" + partition
+        lock, ok := a.partitions[key]
+        if !ok {
+                lock = &sync.Mutex{}
+                a.partitions[key] = lock
+        }
+        return lock
+}
+
+// Function to read the numbers of a range that already exist in its partition
+func numbersInUse(ctx context.Context, dr *DNRange) (map[uint64]bool, error) {
+        partition := "n.fkroutepartition IS NULL"
+        if dr.Partition != "" {
+                partition = "n.fkroutepartition = (SELECT pkid FROM routepartition WHERE name = " + sqlQuote(dr.Partition) + ")"
+        }
+        sql := fmt.Sprintf("SELECT n.dnorpattern FROM numplan n WHERE %s AND LENGTH(n.dnorpattern) = %d AND n.dnorpattern BETWEEN %s AND %s",
+                partition, len(dr.Start), sqlQuote(dr.Start), sqlQuote(dr.End))
+
+        rows, err := executeSQLQuery(ctx, sql)
+        if err != nil {
+                return nil, err
+        }
+
+        used := make(map[uint64]bool, len(rows))
+        for _, row := range rows {
+                // patterns such as 41XX are not plain numbers and never match a candidate
+                if n, err := strconv.ParseUint(row["dnorpattern"], 10, 64); err == nil {
+                        used[n] = true
+                }
+        }
+        return used, nil
+}
+
+// Function to replace "auto:<range>" patterns with reserved numbers; the caller must call
+// release once the AXL request has completed
+func allocatePattern(ctx context.Context, pattern, partition *string, reservations *[]DNReservation) error {
+        rangeName, ok := strings.CutPrefix(*pattern, autoPatternPrefix)
+        if !ok {
+                return nil
+        }
+        dr, err := findDNRange(clusterFrom(ctx), rangeName)
+        if err != nil {
+                return err
+        }
+        if *partition != "" && *partition != dr.Partition {
+                return fmt.Errorf("%w: %q is not %q (range %q)", ErrDNRangeMismatch, *partition, dr.Partition, rangeName)
+        }
+        res, err := allocator.allocate(ctx, rangeName)
+        if err != nil {
+                return err
+        }
+        *reservations = append(*reservations, res)
+        *pattern = res.Pattern
+        *partition = res.RoutePartitionName
+        return nil
+}
+
+// Function to release every reservation taken for a request
+func releaseReservations(reservations []DNReservation) {
+        for _, res := range reservations {
+                allocator.release(res)
+        }
+}
+
+// Function to send allocation failures back with a matching status code
+func allocationErrorResponse(w http.ResponseWriter, err error) {
+        switch {
+        case errors.Is(err, ErrDNRangeExhausted):
+                errorResponse(w, http.StatusConflict, err.Error(), nil)
+        case errors.Is(err, ErrUnknownDNRange), errors.Is(err, ErrDNRangeMismatch):
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+        default:
+                axlErrorResponse(w, err)
+        }
+}
@@ -0,0 +1,230 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "bytes"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "io"
+        "log"
+        "net/http"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// ErrorResponse is the body of every error answer. Code is a stable machine-readable name,
+// Details carries what the client needs to fix the request and Fault the parsed AXL fault, if any
+type ErrorResponse struct {
+        Status    string      `json:"status"`
+        Code      string      `json:"code"`
+        Message   string      `json:"message"`
+        Details   interface{} `json:"details,omitempty"`
+        Fault     *AXLFault   `json:"fault,omitempty"`
+        RequestID string      `json:"requestId,omitempty"`
+}
+
+// BodyDecodeError points at the part of a JSON request body that could not be decoded
+type BodyDecodeError struct {
+        // Field is the dotted path of the struct field, when the value had the wrong type
+        Field    string `json:"field,omitempty"`
+        Expected string `json:"expected,omitempty"`
+        Got      string `json:"got,omitempty"`
+        // Offset is the byte offset in the body, Line and Column the same position counted from 1
+        Offset int64  `json:"offset"`
+        Line   int    `json:"line,omitempty"`
+        Column int    `json:"column,omitempty"`
+        Reason string `json:"reason"`
+}
+
+// NestedDecodeError is returned by a type's own UnmarshalJSON for the value of the top-level body key Key.
+// The offsets in Err count from the start of that value; newBodyDecodeError moves them onto the body
+type NestedDecodeError struct {
+        Key string
+        // Expected describes the value, for when it has the wrong type altogether
+        Expected string
+        Err      error
+}
+
+// Error codes for statuses that have no more specific code
+var statusErrorCodes = map[int]string{
+        http.StatusBadRequest:          "bad_request",
+        http.StatusUnauthorized:        "unauthorized",
+        http.StatusForbidden:           "forbidden",
+        http.StatusNotFound:            "not_found",
+        http.StatusMethodNotAllowed:    "method_not_allowed",
+        http.StatusConflict:            "conflict",
+        http.StatusUnprocessableEntity: "validation_failed",
+        http.StatusInternalServerError: "internal_error",
+        http.StatusBadGateway:          "bad_gateway",
+        http.StatusServiceUnavailable:  "unavailable",
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to pick the code for a status without a more specific one
+func statusErrorCode(statusCode int) string {
+        if code, ok := statusErrorCodes[statusCode]; ok {
+                return code
+        }
+        return strings.ReplaceAll(strings.ToLower(http.StatusText(statusCode)), " ", "_")
+}
+
+// Function to decode a JSON request body into v; a body that does not decode gives a *BodyDecodeError
+func decodeJSON(r *http.Request, v interface{}) error {
+        body, err := io.ReadAll(r.Body)
+        if err != nil {
+                return fmt.Errorf("failed to read request body: %v", err)
+        }
+        if err := json.Unmarshal(body, v); err != nil {
+                return newBodyDecodeError(body, err)
+        }
+        return nil
+}
+
+// Function to describe a JSON decoding error with its position in the body
+func newBodyDecodeError(body []byte, err error) *BodyDecodeError {
+        e := &BodyDecodeError{Reason: err.Error()}
+
+        // errors from inside a nested value are moved to where that value starts in the body
+        var nested *NestedDecodeError
+        var base int64
+        var prefix string
+        if errors.As(err, &nested) {
+                base = topLevelValueOffset(body, nested.Key)
+                prefix = nested.Key
+        }
+
+        var syntaxErr *json.SyntaxError
+        var typeErr *json.UnmarshalTypeError
+        switch {
+        case len(bytes.TrimSpace(body)) == 0:
+                e.Reason = "request body is empty"
+                return e
+        case errors.As(err, &syntaxErr):
+                e.Offset = base + syntaxErr.Offset
+        case errors.As(err, &typeErr):
+                e.Field = typeErr.Field
+                e.Expected = typeErr.Type.String()
+                e.Got = typeErr.Value
+                e.Offset = base + typeErr.Offset
+                if nested != nil {
+                        e.Field = strings.TrimSuffix(prefix+"."+typeErr.Field, ".")
+                        if typeErr.Field == "" {
+                                // the nested value itself had the wrong type
+                                e.Expected = nested.Expected
+                                e.Offset = base
+                        }
+                }
+                e.Reason = fmt.Sprintf("expected %s, got %s", e.Expected, e.Got)
+        default:
+                // errors from a type's own UnmarshalJSON carry no position
+                return e
+        }
+
+        if e.Offset > int64(len(body)) {
+                e.Offset = int64(len(body))
+        }
+        before := body[:e.Offset]
+        e.Line = bytes.Count(before, []byte("\n")) + 1
+        e.Column = len(before) - bytes.LastIndexByte(before, '\n')
+        return e
+}
+
+// Function to find where the value of a top-level key starts in a JSON object body; keys match
+// without regard to case, as encoding/json matches them
+func topLevelValueOffset(body []byte, key string) int64 {
+        dec := json.NewDecoder(bytes.NewReader(body))
+        if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+                return 0
+        }
+        for dec.More() {
+                tok, err := dec.Token()
+                if err != nil {
+                        return 0
+                }
+                if name, _ := tok.(string); strings.EqualFold(name, key) {
+                        // the decoder stops after the key; the value follows the colon and any space
+                        rest := body[dec.InputOffset():]
+                        return dec.InputOffset() + int64(len(rest)-len(bytes.TrimLeft(rest, " \t\r\n:")))
+                }
+                var value json.RawMessage
+                if err := dec.Decode(&value); err != nil {
+                        return 0
+                }
+        }
+        return 0
+}
+
+// Function to describe the error in one line
+func (e *NestedDecodeError) Error() string {
+        return e.Key + ": " + e.Err.Error()
+}
+
+// Function to give errors.As the error from inside the value
+func (e *NestedDecodeError) Unwrap() error {
+        return e.Err
+}
+
+// Function to describe the error in one line
+func (e *BodyDecodeError) Error() string {
+        msg := "invalid JSON body: " + e.Reason
+        if e.Field != "" {
+                msg += " in field " + e.Field
+        }
+        if e.Line > 0 {
+                msg += fmt.Sprintf(" at line %d, column %d", e.Line, e.Column)
+        }
+        return msg
+}
+
+// Function to send an error envelope; the request ID is the one requestIDRouter put on the response
+func writeErrorResponse(w http.ResponseWriter, statusCode int, resp ErrorResponse) {
+        resp.Status = "error"
+        if resp.Code == "" {
+                resp.Code = statusErrorCode(statusCode)
+        }
+        resp.RequestID = w.Header().Get(requestIDHeader)
+
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(statusCode)
+        json.NewEncoder(w).Encode(resp)
+
+        line, _ := json.Marshal(resp)
+        log.Printf("error %s", line)
+}
+
+// Function to answer a request whose JSON body could not be decoded
+func decodeErrorResponse(w http.ResponseWriter, err error) {
+        var decodeErr *BodyDecodeError
+        if errors.As(err, &decodeErr) {
+                writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{Code: "invalid_body", Message: decodeErr.Error(), Details: decodeErr})
+                return
+        }
+        writeErrorResponse(w, http.StatusBadRequest, ErrorResponse{Code: "invalid_body", Message: err.Error()})
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for paths no other handler matches, so they get the error envelope too
+func handleUnknownPath(w http.ResponseWriter, r *http.Request) {
+        errorResponse(w, http.StatusNotFound, fmt.Sprintf("Unknown path %s", r.URL.Path), nil)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+        "encoding/json"
+        "testing"
+)
+
+func TestNewBodyDecodeError(t *testing.T) {
+        // positions follow encoding/json: a wrong type is reported just after the value, a syntax error just after
+        // the offending character, and inside lines they must agree with the same mistake at the top level
+        tests := []struct {
+                name     string
+                body     string
+                field    string
+                expected string
+                line     int
+                column   int
+        }{
+                {name: "top-level type", body: `{"name": 5}`, field: "name", expected: "string", line: 1, column: 11},
+                {
+                        name:   "top-level syntax",
+                        body:   "{\"name\": \"SEP1\",\n \"description\": \"x\"\n \"product\": \"y\"}",
+                        line:   3,
+                        column: 3,
+                },
+                {
+                        name:     "inside wrapped lines",
+                        body:     "{\"name\": \"SEP1\",\n \"maxNumCalls\": 2,\n \"lines\": {\"line\": [{\"index\": \"one\"}]}}",
+                        field:    "lines.line.0.index",
+                        expected: "int",
+                        line:     3,
+                        column:   36,
+                },
+                {
+                        name:     "inside a bare list of lines",
+                        body:     "{\"name\": \"SEP1\",\n \"lines\": [\n  {\"index\": 1},\n  {\"index\": \"two\"}\n ]}",
+                        field:    "lines.1.index",
+                        expected: "int",
+                        line:     4,
+                        column:   18,
+                },
+                {
+                        name:     "deeper inside a line",
+                        body:     "{\"name\": \"SEP1\",\n \"lines\": [{\"dirn\": {\"pattern\": 1000}}]}",
+                        field:    "lines.0.dirn.pattern",
+                        expected: "string",
+                        line:     2,
+                        column:   37,
+                },
+                {
+                        name:     "lines of the wrong type, key in another case",
+                        body:     "{\"name\": \"SEP1\",\n \"LINES\":   \"all\"}",
+                        field:    "lines",
+                        expected: linesExpected,
+                        line:     2,
+                        column:   13,
+                },
+                {
+                        name:   "syntax inside lines",
+                        body:   "{\"name\": \"SEP1\",\n \"lines\": [{\"index\": 1,}]}",
+                        line:   2,
+                        column: 25,
+                },
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        var req AddPhoneReq
+                        err := json.Unmarshal([]byte(tt.body), &req)
+                        if err == nil {
+                                t.Fatal("body decoded without an error")
+                        }
+                        e := newBodyDecodeError([]byte(tt.body), err)
+                        if e.Field != tt.field || e.Expected != tt.expected {
+                                t.Errorf("field %q expecting %q, want %q expecting %q", e.Field, e.Expected, tt.field, tt.expected)
+                        }
+                        if e.Line != tt.line || e.Column != tt.column {
+                                t.Errorf("at line %d, column %d, want line %d, column %d (%s)", e.Line, e.Column, tt.line, tt.column, e.Reason)
+                        }
+                })
+        }
+
+        var req AddPhoneReq
+        body := []byte(" \n ")
+        if e := newBodyDecodeError(body, json.Unmarshal(body, &req)); e.Reason != "request body is empty" || e.Line != 0 {
+                t.Errorf("empty body gave %+v", e)
+        }
+}
+
+func TestTopLevelValueOffset(t *testing.T) {
+        tests := []struct {
+                name string
+                body string
+                key  string
+                want int64
+        }{
+                {name: "first key", body: `{"lines": []}`, key: "lines", want: 10},
+                {name: "after other values", body: `{"a": {"lines": 1}, "b": [1, 2], "lines": []}`, key: "lines", want: 42},
+                {name: "case-insensitive", body: "{\n  \"Lines\" :\n [] }", key: "lines", want: 15},
+                {name: "missing key", body: `{"a": 1}`, key: "lines", want: 0},
+                {name: "not an object", body: `[1, 2]`, key: "lines", want: 0},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        if got := topLevelValueOffset([]byte(tt.body), tt.key); got != tt.want {
+                                t.Errorf("got %d, want %d", got, tt.want)
+                        }
+                })
+        }
+}
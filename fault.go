@@ -0,0 +1,205 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "encoding/xml"
+        "errors"
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// AXLFault is a SOAP fault (or bare HTTP error) returned by the AXL service
+type AXLFault struct {
+        HTTPStatus  int    `json:"httpStatus"`
+        FaultCode   string `json:"faultCode,omitempty"`
+        FaultString string `json:"faultString,omitempty"`
+        AXLCode     int    `json:"axlCode,omitempty"`
+        AXLMessage  string `json:"axlMessage,omitempty"`
+        Request     string `json:"request,omitempty"`
+}
+
+// soapFaultResp structure for a SOAP fault response
+type soapFaultResp struct {
+        XMLName xml.Name `xml:"Envelope"`
+        Body    struct {
+                Fault *struct {
+                        FaultCode   string `xml:"faultcode"`
+                        FaultString string `xml:"faultstring"`
+                        Detail      struct {
+                                AXLError struct {
+                                        AXLCode    string `xml:"axlcode"`
+                                        AXLMessage string `xml:"axlmessage"`
+                                        Request    string `xml:"request"`
+                                } `xml:"axlError"`
+                        } `xml:"detail"`
+                } `xml:"Fault"`
+        } `xml:"Body"`
+}
+
+// Fault classes that handlers can test for with errors.Is
+var (
+        ErrAXLNotFound      = errors.New("AXL object not found")
+        ErrAXLDuplicate     = errors.New("AXL object already exists")
+        ErrAXLUnauthorized  = errors.New("AXL credentials rejected")
+        ErrAXLThrottled     = errors.New("AXL service is throttling requests")
+        ErrAXLQueryTooLarge = errors.New("AXL query result too large")
+)
+
+// AXL error codes reported for duplicate keys (Informix unique constraint violations)
+var axlDuplicateCodes = map[int]bool{
+        -239: true,
+        -268: true,
+        -100: true,
+}
+
+// AXL error codes reported when a referenced object does not exist
+var axlNotFoundCodes = map[int]bool{
+        5007: true,
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to turn a non-200 or fault-carrying AXL response into an *AXLFault
+func parseAXLFault(statusCode int, body []byte) error {
+        var resp soapFaultResp
+        if err := xml.Unmarshal(body, &resp); err == nil && resp.Body.Fault != nil {
+                f := resp.Body.Fault
+                fault := &AXLFault{
+                        HTTPStatus:  statusCode,
+                        FaultCode:   f.FaultCode,
+                        FaultString: strings.TrimSpace(f.FaultString),
+                        AXLMessage:  strings.TrimSpace(f.Detail.AXLError.AXLMessage),
+                        Request:     f.Detail.AXLError.Request,
+                }
+                if code, err := strconv.Atoi(strings.TrimSpace(f.Detail.AXLError.AXLCode)); err == nil {
+                        fault.AXLCode = code
+                }
+                return fault
+        }
+
+        if statusCode == http.StatusOK {
+                return nil
+        }
+
+        return &AXLFault{
+                HTTPStatus:  statusCode,
+                FaultString: http.StatusText(statusCode),
+        }
+}
+
+// Function to describe the fault
+func (f *AXLFault) Error() string {
+        msg := f.AXLMessage
+        if msg == "" {
+                msg = f.FaultString
+        }
+        if f.AXLCode != 0 {
+                return fmt.Sprintf("AXL fault %d: %s", f.AXLCode, msg)
+        }
+        return fmt.Sprintf("AXL error (HTTP %d): %s", f.HTTPStatus, msg)
+}
+
+// Function to match the fault against the ErrAXL* classes
+func (f *AXLFault) Is(target error) bool {
+        msg := strings.ToLower(f.AXLMessage + " " + f.FaultString)
+
+        switch target {
+        case ErrAXLNotFound:
+                return axlNotFoundCodes[f.AXLCode] || strings.Contains(msg, "not found")
+        case ErrAXLDuplicate:
+                return axlDuplicateCodes[f.AXLCode] || strings.Contains(msg, "duplicate value") || strings.Contains(msg, "already exists")
+        case ErrAXLUnauthorized:
+                return f.HTTPStatus == http.StatusUnauthorized || f.HTTPStatus == http.StatusForbidden
+        case ErrAXLThrottled:
+                return f.HTTPStatus == http.StatusServiceUnavailable || strings.Contains(msg, "maximum axl memory allocation consumed")
+        case ErrAXLQueryTooLarge:
+                return strings.Contains(msg, "query request too large")
+        }
+        return false
+}
+
+// Function to pick the REST status code that best describes the fault
+func (f *AXLFault) StatusCode() int {
+        switch {
+        case errors.Is(f, ErrAXLUnauthorized):
+                return http.StatusUnauthorized
+        case errors.Is(f, ErrAXLThrottled):
+                return http.StatusServiceUnavailable
+        case errors.Is(f, ErrAXLNotFound):
+                return http.StatusNotFound
+        case errors.Is(f, ErrAXLDuplicate):
+                return http.StatusConflict
+        case strings.HasSuffix(f.FaultCode, "Client"):
+                return http.StatusBadRequest
+        }
+        return http.StatusBadGateway
+}
+
+// Function to name the fault's class for the error envelope's code
+func (f *AXLFault) errorCode() string {
+        switch {
+        case errors.Is(f, ErrAXLUnauthorized):
+                return "axl_unauthorized"
+        case errors.Is(f, ErrAXLThrottled):
+                return "axl_throttled"
+        case errors.Is(f, ErrAXLNotFound):
+                return "axl_not_found"
+        case errors.Is(f, ErrAXLDuplicate):
+                return "axl_duplicate"
+        case errors.Is(f, ErrAXLQueryTooLarge):
+                return "axl_query_too_large"
+        }
+        return "axl_fault"
+}
+
+// Function to build the error envelope and status for an error returned by an AXL call
+func axlError(err error) (int, ErrorResponse) {
+        var fault *AXLFault
+        if errors.As(err, &fault) {
+                return fault.StatusCode(), ErrorResponse{Code: fault.errorCode(), Message: fault.Error(), Fault: fault}
+        }
+        var unsupported *UnsupportedFieldsError
+        if errors.As(err, &unsupported) {
+                return http.StatusBadRequest, ErrorResponse{Code: "unsupported_fields", Message: unsupported.Error(), Details: unsupported}
+        }
+        if errors.Is(err, ErrPublisherUnavailable) {
+                return http.StatusServiceUnavailable, ErrorResponse{Code: "publisher_unavailable", Message: err.Error()}
+        }
+        if errors.Is(err, ErrNoNodeAvailable) {
+                return http.StatusServiceUnavailable, ErrorResponse{Code: "no_node_available", Message: err.Error()}
+        }
+        if errors.Is(err, ErrAXLTimeout) {
+                return http.StatusGatewayTimeout, ErrorResponse{Code: "axl_timeout", Message: err.Error()}
+        }
+        if errors.Is(err, ErrAXLVersionUnknown) {
+                return http.StatusServiceUnavailable, ErrorResponse{Code: "axl_version_unknown", Message: err.Error()}
+        }
+        return http.StatusInternalServerError, ErrorResponse{Code: "forward_failed", Message: "Failed to forward request"}
+}
+
+// Function to send an AXL error back to the client as a JSON error envelope
+func axlErrorResponse(w http.ResponseWriter, err error) {
+        statusCode, resp := axlError(err)
+        if resp.Code == "forward_failed" {
+                // the cause stays in the log; it can name hosts and files the client has no use for
+                logResponse("error", err.Error())
+        }
+        writeErrorResponse(w, statusCode, resp)
+}
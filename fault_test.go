@@ -0,0 +1,129 @@
+package main
+
+import (
+        "errors"
+        "fmt"
+        "net/http"
+        "testing"
+)
+
+// Function to build the SOAP fault envelope CUCM sends for an AXL error
+func soapFaultBody(faultCode, faultString, axlCode, axlMessage string) []byte {
+        return []byte(`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><soapenv:Fault>` +
+                `<faultcode>` + faultCode + `</faultcode><faultstring>` + faultString + `</faultstring>` +
+                `<detail><axlError><axlcode>` + axlCode + `</axlcode><axlmessage>` + axlMessage + `</axlmessage><request>addPhone</request></axlError></detail>` +
+                `</soapenv:Fault></soapenv:Body></soapenv:Envelope>`)
+}
+
+func TestParseAXLFault(t *testing.T) {
+        tests := []struct {
+                name   string
+                status int
+                body   []byte
+                want   *AXLFault
+        }{
+                {name: "success", status: http.StatusOK, body: []byte(`<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body/></soapenv:Envelope>`)},
+                {
+                        name:   "axl fault",
+                        status: http.StatusInternalServerError,
+                        body:   soapFaultBody("soapenv:Client", " Could not insert new row - duplicate value in a UNIQUE INDEX column ", " -239 ", "Could not insert new row"),
+                        want: &AXLFault{
+                                HTTPStatus:  http.StatusInternalServerError,
+                                FaultCode:   "soapenv:Client",
+                                FaultString: "Could not insert new row - duplicate value in a UNIQUE INDEX column",
+                                AXLCode:     -239,
+                                AXLMessage:  "Could not insert new row",
+                                Request:     "addPhone",
+                        },
+                },
+                {
+                        name:   "fault without an axl code",
+                        status: http.StatusInternalServerError,
+                        body:   soapFaultBody("soapenv:Server", "Item not valid: The specified Phone was not found", "", ""),
+                        want: &AXLFault{
+                                HTTPStatus:  http.StatusInternalServerError,
+                                FaultCode:   "soapenv:Server",
+                                FaultString: "Item not valid: The specified Phone was not found",
+                                Request:     "addPhone",
+                        },
+                },
+                {name: "bare 401", status: http.StatusUnauthorized, body: []byte("<html>Unauthorized</html>"), want: &AXLFault{HTTPStatus: http.StatusUnauthorized, FaultString: "Unauthorized"}},
+                {name: "bare 503", status: http.StatusServiceUnavailable, body: nil, want: &AXLFault{HTTPStatus: http.StatusServiceUnavailable, FaultString: "Service Unavailable"}},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        err := parseAXLFault(tt.status, tt.body)
+                        if tt.want == nil {
+                                if err != nil {
+                                        t.Fatalf("unexpected error: %v", err)
+                                }
+                                return
+                        }
+                        var fault *AXLFault
+                        if !errors.As(err, &fault) {
+                                t.Fatalf("got %v, want an *AXLFault", err)
+                        }
+                        if *fault != *tt.want {
+                                t.Errorf("got %+v, want %+v", *fault, *tt.want)
+                        }
+                })
+        }
+}
+
+func TestAXLError(t *testing.T) {
+        tests := []struct {
+                name   string
+                err    error
+                status int
+                code   string
+        }{
+                {name: "bad credentials", err: &AXLFault{HTTPStatus: http.StatusUnauthorized}, status: http.StatusUnauthorized, code: "axl_unauthorized"},
+                {name: "forbidden", err: &AXLFault{HTTPStatus: http.StatusForbidden}, status: http.StatusUnauthorized, code: "axl_unauthorized"},
+                {name: "throttled by status", err: &AXLFault{HTTPStatus: http.StatusServiceUnavailable}, status: http.StatusServiceUnavailable, code: "axl_throttled"},
+                {
+                        name:   "throttled by message",
+                        err:    &AXLFault{HTTPStatus: http.StatusInternalServerError, FaultCode: "soapenv:Server", FaultString: "Maximum AXL Memory Allocation Consumed"},
+                        status: http.StatusServiceUnavailable,
+                        code:   "axl_throttled",
+                },
+                {name: "not found by code", err: &AXLFault{HTTPStatus: http.StatusInternalServerError, AXLCode: 5007}, status: http.StatusNotFound, code: "axl_not_found"},
+                {
+                        name:   "not found by message",
+                        err:    &AXLFault{HTTPStatus: http.StatusInternalServerError, FaultCode: "soapenv:Server", FaultString: "Item not valid: The specified Phone was not found"},
+                        status: http.StatusNotFound,
+                        code:   "axl_not_found",
+                },
+                {name: "duplicate by code", err: &AXLFault{HTTPStatus: http.StatusInternalServerError, AXLCode: -239}, status: http.StatusConflict, code: "axl_duplicate"},
+                {
+                        name:   "duplicate by message",
+                        err:    &AXLFault{HTTPStatus: http.StatusInternalServerError, FaultCode: "soapenv:Client", FaultString: "The user already exists"},
+                        status: http.StatusConflict,
+                        code:   "axl_duplicate",
+                },
+                {
+                        name:   "query too large",
+                        err:    &AXLFault{HTTPStatus: http.StatusInternalServerError, FaultCode: "soapenv:Client", FaultString: "Query request too large. Total rows matched: 48000 rows. Suggestive Row Fetch: less than 12000 rows"},
+                        status: http.StatusBadRequest,
+                        code:   "axl_query_too_large",
+                },
+                {name: "other client fault", err: &AXLFault{HTTPStatus: http.StatusInternalServerError, FaultCode: "soapenv:Client", FaultString: "Invalid value"}, status: http.StatusBadRequest, code: "axl_fault"},
+                {name: "other server fault", err: &AXLFault{HTTPStatus: http.StatusInternalServerError, FaultCode: "soapenv:Server", FaultString: "Unexpected"}, status: http.StatusBadGateway, code: "axl_fault"},
+                {name: "wrapped fault", err: fmt.Errorf("reading phone: %w", &AXLFault{HTTPStatus: http.StatusInternalServerError, AXLCode: 5007}), status: http.StatusNotFound, code: "axl_not_found"},
+                {name: "unsupported fields", err: &UnsupportedFieldsError{Version: "11.5", Fields: []string{"wifiHotspotProfile (AXL 12.0)"}}, status: http.StatusBadRequest, code: "unsupported_fields"},
+                {name: "publisher unavailable", err: fmt.Errorf("%w: cucm-pub", ErrPublisherUnavailable), status: http.StatusServiceUnavailable, code: "publisher_unavailable"},
+                {name: "no node available", err: fmt.Errorf("%w on cluster hq", ErrNoNodeAvailable), status: http.StatusServiceUnavailable, code: "no_node_available"},
+                {name: "timeout", err: fmt.Errorf("%w: read tcp", ErrAXLTimeout), status: http.StatusGatewayTimeout, code: "axl_timeout"},
+                {name: "version unknown", err: fmt.Errorf("%w on cluster hq", ErrAXLVersionUnknown), status: http.StatusServiceUnavailable, code: "axl_version_unknown"},
+                {name: "anything else", err: errors.New("dial tcp: lookup cucm: no such host"), status: http.StatusInternalServerError, code: "forward_failed"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        status, resp := axlError(tt.err)
+                        if status != tt.status || resp.Code != tt.code {
+                                t.Errorf("got %d %s, want %d %s", status, resp.Code, tt.status, tt.code)
+                        }
+                })
+        }
+}
@@ -2,6 +2,11 @@ module cm-gator
 
 go 1.21.5
 
+require (
+	github.com/BurntSushi/toml v1.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/tiaguinho/gosoap v1.4.4 // indirect
 	golang.org/x/net v0.0.0-20191209160850-c0dbc17a3553 // indirect
@@ -0,0 +1,383 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/xml"
+        "net/http"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// DirectoryNumber is the JSON model for a CUCM line (AXL XLine), used by add, get, update and list
+type DirectoryNumber struct {
+        Pattern                     string           `json:"pattern" xml:"pattern"`
+        Description                 string           `json:"description,omitempty" xml:"description,omitempty"`
+        Usage                       string           `json:"usage,omitempty" xml:"usage,omitempty"`
+        RoutePartitionName          string           `json:"routePartitionName,omitempty" xml:"routePartitionName,omitempty"`
+        CallForwardAll              *CallForward     `json:"callForwardAll,omitempty" xml:"callForwardAll,omitempty"`
+        CallForwardBusy             *CallForward     `json:"callForwardBusy,omitempty" xml:"callForwardBusy,omitempty"`
+        CallForwardBusyInt          *CallForward     `json:"callForwardBusyInt,omitempty" xml:"callForwardBusyInt,omitempty"`
+        CallForwardNoAnswer         *CallForward     `json:"callForwardNoAnswer,omitempty" xml:"callForwardNoAnswer,omitempty"`
+        CallForwardNoAnswerInt      *CallForward     `json:"callForwardNoAnswerInt,omitempty" xml:"callForwardNoAnswerInt,omitempty"`
+        CallForwardNoCoverage       *CallForward     `json:"callForwardNoCoverage,omitempty" xml:"callForwardNoCoverage,omitempty"`
+        CallForwardNoCoverageInt    *CallForward     `json:"callForwardNoCoverageInt,omitempty" xml:"callForwardNoCoverageInt,omitempty"`
+        CallForwardOnFailure        *CallForward     `json:"callForwardOnFailure,omitempty" xml:"callForwardOnFailure,omitempty"`
+        CallForwardNotRegistered    *CallForward     `json:"callForwardNotRegistered,omitempty" xml:"callForwardNotRegistered,omitempty"`
+        CallForwardNotRegisteredInt *CallForward     `json:"callForwardNotRegisteredInt,omitempty" xml:"callForwardNotRegisteredInt,omitempty"`
+        CallPickupGroupName         string           `json:"callPickupGroupName,omitempty" xml:"callPickupGroupName,omitempty"`
+        AlertingName                string           `json:"alertingName,omitempty" xml:"alertingName,omitempty"`
+        AsciiAlertingName           string           `json:"asciiAlertingName,omitempty" xml:"asciiAlertingName,omitempty"`
+        PresenceGroupName           string           `json:"presenceGroupName,omitempty" xml:"presenceGroupName,omitempty"`
+        ShareLineAppearanceCssName  string           `json:"shareLineAppearanceCssName,omitempty" xml:"shareLineAppearanceCssName,omitempty"`
+        VoiceMailProfileName        string           `json:"voiceMailProfileName,omitempty" xml:"voiceMailProfileName,omitempty"`
+        PatternPrecedence           string           `json:"patternPrecedence,omitempty" xml:"patternPrecedence,omitempty"`
+        CfaCssPolicy                string           `json:"cfaCssPolicy,omitempty" xml:"cfaCssPolicy,omitempty"`
+        RejectAnonymousCall         *bool            `json:"rejectAnonymousCall,omitempty" xml:"rejectAnonymousCall,omitempty" axlsince:"11.5"`
+        ExternalCallControlProfile  string           `json:"externalCallControlProfile,omitempty" xml:"externalCallControlProfile,omitempty"`
+        EnterpriseAltNum            *AlternateNumber `json:"enterpriseAltNum,omitempty" xml:"enterpriseAltNum,omitempty"`
+        E164AltNum                  *AlternateNumber `json:"e164AltNum,omitempty" xml:"e164AltNum,omitempty"`
+        AssociatedDevices           *Devices         `json:"associatedDevices,omitempty" xml:"associatedDevices,omitempty"`
+}
+
+// CallForward describes one call forward type of a line; duration only applies to no-answer forwarding
+type CallForward struct {
+        ForwardToVoiceMail              *bool  `json:"forwardToVoiceMail,omitempty" xml:"forwardToVoiceMail,omitempty"`
+        CallingSearchSpaceName          string `json:"callingSearchSpaceName,omitempty" xml:"callingSearchSpaceName,omitempty"`
+        SecondaryCallingSearchSpaceName string `json:"secondaryCallingSearchSpaceName,omitempty" xml:"secondaryCallingSearchSpaceName,omitempty"`
+        Destination                     string `json:"destination,omitempty" xml:"destination,omitempty"`
+        Duration                        int    `json:"duration,omitempty" xml:"duration,omitempty"`
+}
+
+// AlternateNumber is an enterprise or +E.164 alternate number of a line
+type AlternateNumber struct {
+        NumberMask             string `json:"numberMask,omitempty" xml:"numberMask,omitempty"`
+        IsUrgent               *bool  `json:"isUrgent,omitempty" xml:"isUrgent,omitempty"`
+        AddLocalRoutePartition *bool  `json:"addLocalRoutePartition,omitempty" xml:"addLocalRoutePartition,omitempty"`
+        RoutePartition         string `json:"routePartition,omitempty" xml:"routePartition,omitempty"`
+        AdvertiseGloballyIls   *bool  `json:"advertiseGloballyIls,omitempty" xml:"advertiseGloballyIls,omitempty"`
+}
+
+// AddLineAXLReq structure for SOAP request
+type AddLineAXLReq struct {
+        XMLName xml.Name         `xml:"axl:addLine"`
+        Line    *DirectoryNumber `xml:"line"`
+}
+
+// AddLineResp structure for SOAP response
+type AddLineResp struct {
+        Body struct {
+                AddLineResponse struct {
+                        Return string `xml:"return"`
+                } `xml:"addLineResponse"`
+        } `xml:"Body"`
+}
+
+// GetLineAXLReq structure for SOAP request
+type GetLineAXLReq struct {
+        XMLName            xml.Name     `xml:"axl:getLine"`
+        Pattern            string       `xml:"pattern"`
+        RoutePartitionName string       `xml:"routePartitionName"`
+        ReturnedTags       ReturnedTags `xml:"returnedTags,omitempty"`
+}
+
+// GetLineResp structure for SOAP response
+type GetLineResp struct {
+        Body struct {
+                GetLineResponse struct {
+                        Return struct {
+                                Line DirectoryNumber `xml:"line"`
+                        } `xml:"return"`
+                } `xml:"getLineResponse"`
+        } `xml:"Body"`
+}
+
+// UpdateLineAXLReq structure for SOAP request; Fields holds only the elements being changed
+type UpdateLineAXLReq struct {
+        XMLName               xml.Name `xml:"axl:updateLine"`
+        Pattern               string   `xml:"pattern"`
+        RoutePartitionName    string   `xml:"routePartitionName"`
+        NewPattern            string   `xml:"newPattern,omitempty"`
+        NewRoutePartitionName *string  `xml:"newRoutePartitionName,omitempty"`
+        Fields                []rawElement
+}
+
+// RemoveLineAXLReq structure for SOAP request
+type RemoveLineAXLReq struct {
+        XMLName            xml.Name `xml:"axl:removeLine"`
+        Pattern            string   `xml:"pattern"`
+        RoutePartitionName string   `xml:"routePartitionName"`
+}
+
+// ListLineAXLReq structure for SOAP request
+type ListLineAXLReq struct {
+        XMLName        xml.Name           `xml:"axl:listLine"`
+        SearchCriteria LineSearchCriteria `xml:"searchCriteria"`
+        ReturnedTags   ReturnedTags       `xml:"returnedTags"`
+        Skip           int                `xml:"skip,omitempty"`
+        First          int                `xml:"first,omitempty"`
+}
+
+// LineSearchCriteria are the AXL listLine filters; % is the wildcard
+type LineSearchCriteria struct {
+        Pattern            string `xml:"pattern,omitempty"`
+        Description        string `xml:"description,omitempty"`
+        Usage              string `xml:"usage,omitempty"`
+        RoutePartitionName string `xml:"routePartitionName,omitempty"`
+}
+
+// ListLineResp structure for SOAP response
+type ListLineResp struct {
+        Body struct {
+                ListLineResponse struct {
+                        Return struct {
+                                Line []DirectoryNumber `xml:"line"`
+                        } `xml:"return"`
+                } `xml:"listLineResponse"`
+        } `xml:"Body"`
+}
+
+// Fields returned by GET /lines when the caller does not choose any
+var defaultLineListFields = []string{"pattern", "routePartitionName", "description", "alertingName"}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for the line collection: GET lists, POST adds
+func handleLinesRequest(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+                handleListLinesRequest(w, r)
+        case http.MethodPost:
+                handleAddLineRequest(w, r)
+        default:
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+        }
+}
+
+// Handler function for a single line, keyed by /lines/{pattern}?routePartitionName=
+func handleLineRequest(w http.ResponseWriter, r *http.Request) {
+        pattern := strings.TrimPrefix(r.URL.Path, "/lines/")
+        if pattern == "" || strings.Contains(pattern, "/") {
+                errorResponse(w, http.StatusNotFound, "Unknown line path", nil)
+                return
+        }
+        // an absent routePartitionName means the line is in the <None> partition
+        partition := r.URL.Query().Get("routePartitionName")
+
+        switch r.Method {
+        case http.MethodGet:
+                handleGetLineRequest(w, r, pattern, partition)
+        case http.MethodPatch:
+                handleUpdateLineRequest(w, r, pattern, partition)
+        case http.MethodDelete:
+                handleRemoveLineRequest(w, r, pattern, partition)
+        default:
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+        }
+}
+
+// Handler function for adding an unassigned directory number
+func handleAddLineRequest(w http.ResponseWriter, r *http.Request) {
+        var req DirectoryNumber
+        if err := decodeJSON(r, &req); err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+        if req.Pattern == "" {
+                errorResponse(w, http.StatusBadRequest, "pattern is required", nil)
+                return
+        }
+        // associatedDevices is read-only; devices are attached through the phone's lines
+        req.AssociatedDevices = nil
+
+        var reservations []DNReservation
+        defer func() { releaseReservations(reservations) }()
+        if err := allocatePattern(r.Context(), &req.Pattern, &req.RoutePartitionName, &reservations); err != nil {
+                allocationErrorResponse(w, err)
+                return
+        }
+
+        var resp AddLineResp
+        if err := callAXL(r.Context(), &AddLineAXLReq{Line: &req}, &resp); err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Line added successfully", map[string]string{
+                "uuid":               resp.Body.AddLineResponse.Return,
+                "pattern":            req.Pattern,
+                "routePartitionName": req.RoutePartitionName,
+        })
+}
+
+// Handler function for reading a line, including the devices that share it
+func handleGetLineRequest(w http.ResponseWriter, r *http.Request, pattern, partition string) {
+        var fields []string
+        if v := r.URL.Query().Get("fields"); v != "" {
+                fields = strings.Split(v, ",")
+        }
+        tags, err := returnedTagsFor(DirectoryNumber{}, fields)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        var resp GetLineResp
+        err = callAXL(r.Context(), &GetLineAXLReq{
+                Pattern:            pattern,
+                RoutePartitionName: partition,
+                ReturnedTags:       tags,
+        }, &resp)
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Line retrieved successfully", resp.Body.GetLineResponse.Return.Line)
+}
+
+// Handler function for updating only the supplied fields of a line
+func handleUpdateLineRequest(w http.ResponseWriter, r *http.Request, pattern, partition string) {
+        var req DirectoryNumber
+        supplied, err := decodePatch(r, &req)
+        if err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+
+        update := &UpdateLineAXLReq{Pattern: pattern, RoutePartitionName: partition}
+        var keys []string
+        for _, key := range supplied {
+                switch key {
+                case "pattern":
+                        if req.Pattern != pattern {
+                                update.NewPattern = req.Pattern
+                        }
+                case "routePartitionName":
+                        if req.RoutePartitionName != partition {
+                                update.NewRoutePartitionName = &req.RoutePartitionName
+                        }
+                case "associatedDevices":
+                        errorResponse(w, http.StatusBadRequest, "associatedDevices is read-only", nil)
+                        return
+                default:
+                        keys = append(keys, key)
+                }
+        }
+
+        // partial updates bypass the check in marshalAXLRequest, so run it on the model
+        dropped, err := checkAXLFields(r.Context(), &req)
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+        keys = removeStrings(keys, dropped)
+
+        tags, err := returnedTagsFor(DirectoryNumber{}, keys)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+        update.Fields, err = partialElements(&req, tagSet(tags))
+        if err != nil {
+                errorResponse(w, http.StatusInternalServerError, "Failed to build request", nil)
+                logResponse("error", err.Error())
+                return
+        }
+        if len(update.Fields) == 0 && update.NewPattern == "" && update.NewRoutePartitionName == nil {
+                errorResponse(w, http.StatusBadRequest, "No fields to update", nil)
+                return
+        }
+
+        var resp StandardResp
+        if err := callAXL(r.Context(), update, &resp); err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Line updated successfully", resp.Body.Response.Return)
+}
+
+// Handler function for removing a line
+func handleRemoveLineRequest(w http.ResponseWriter, r *http.Request, pattern, partition string) {
+        var resp StandardResp
+        err := callAXL(r.Context(), &RemoveLineAXLReq{Pattern: pattern, RoutePartitionName: partition}, &resp)
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Line removed successfully", resp.Body.Response.Return)
+}
+
+// Handler function for searching lines
+func handleListLinesRequest(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        skip, first, err := parsePage(r)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        fields := append([]string(nil), defaultLineListFields...)
+        if v := q.Get("fields"); v != "" {
+                fields = strings.Split(v, ",")
+        }
+        if containsString(fields, "associatedDevices") {
+                errorResponse(w, http.StatusBadRequest, "associatedDevices is only returned by GET /lines/{pattern}", nil)
+                return
+        }
+        tags, err := returnedTagsFor(DirectoryNumber{}, fields)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        criteria := LineSearchCriteria{
+                Pattern:            axlWildcard(q.Get("pattern")),
+                Description:        axlWildcard(q.Get("description")),
+                Usage:              q.Get("usage"),
+                RoutePartitionName: axlWildcard(q.Get("routePartitionName")),
+        }
+        matchAllIfEmpty(&criteria, &criteria.Pattern)
+
+        lines, returned, err := listOnClusters(r.Context(), func(ctx context.Context) (interface{}, int, error) {
+                var resp ListLineResp
+                err := callAXL(ctx, &ListLineAXLReq{
+                        SearchCriteria: criteria,
+                        ReturnedTags:   tags,
+                        Skip:           skip,
+                        First:          first,
+                }, &resp)
+                if err != nil {
+                        return nil, 0, err
+                }
+
+                lines := resp.Body.ListLineResponse.Return.Line
+                if lines == nil {
+                        lines = []DirectoryNumber{}
+                }
+                return lines, len(lines), nil
+        })
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonPageResponse(w, http.StatusOK, "Lines retrieved successfully", lines, nextCursor(skip, first, returned))
+}
@@ -8,14 +8,26 @@ package main
 
 import (
         "bytes"
-        "crypto/tls"
+        "context"
         "encoding/base64"
         "encoding/json"
         "encoding/xml"
+        "errors"
+        "flag"
         "fmt"
         "io/ioutil"
         "log"
+        "net"
         "net/http"
+        "net/http/httptrace"
+        "net/url"
+        "os"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "sync/atomic"
+        "time"
 )
 
 /****
@@ -26,14 +38,8 @@ import (
 
 // ExecuteSQLQueryReq structure for SOAP request
 type ExecuteSQLQueryReq struct {
-        XMLName      xml.Name `xml:"soapenv:Envelope"`
-        XmlnsSoapenv string   `xml:"xmlns:soapenv,attr"`
-        XmlnsAxl     string   `xml:"xmlns:axl,attr"`
-        Body         struct {
-                ExecuteSQLQuery struct {
-                        SQL string `xml:"sql"`
-                } `xml:"axl:executeSQLQuery"`
-        } `xml:"soapenv:Body"`
+        XMLName xml.Name `xml:"axl:executeSQLQuery"`
+        SQL     string   `xml:"sql"`
 }
 
 // ExecuteSQLQueryResp structure for SOAP response
@@ -42,174 +48,276 @@ type ExecuteSQLQueryResp struct {
         Body    struct {
                 ExecuteSQLQueryResponse struct {
                         Return struct {
-                                Rows []struct {
-                                        UserID     string `xml:"userid"`
-                                        FirstName  string `xml:"firstname"`
-                                        LastName   string `xml:"lastname"`
-                                        Department string `xml:"department"`
-                                } `xml:"row"`
+                                Rows []SQLRow `xml:"row"`
                         } `xml:"return"`
                 } `xml:"executeSQLQueryResponse"`
         } `xml:"Body"`
 }
 
+// SQLRow is one executeSQLQuery result row, keyed by column name
+type SQLRow map[string]string
+
 // Define JsonResponse struct
 type JsonResponse struct {
     Status  string      `json:"status"`
     Message string      `json:"message"`
     Data    interface{} `json:"data,omitempty"`
+    Next    string      `json:"next,omitempty"`
 }
 
 type AddPhoneReq struct {
-    Name                           string `json:"name"`
-    Description                    string `json:"description"`
-    Product                        string `json:"product"`
-    Class                          string `json:"class"`
-    Protocol                       string `json:"protocol"`
-    ProtocolSide                   string `json:"protocolSide"`
-    CallingSearchSpaceName         string `json:"callingSearchSpaceName"`
-    DevicePoolName                 string `json:"devicePoolName"`
-    CommonDeviceConfigName         string `json:"commonDeviceConfigName"`
-    CommonPhoneConfigName          string `json:"commonPhoneConfigName"`
-    NetworkLocation                string `json:"networkLocation"`
-    LocationName                   string `json:"locationName"`
-    MediaResourceListName          string `json:"mediaResourceListName"`
-    NetworkHoldMohAudioSourceId    string `json:"networkHoldMohAudioSourceId"`
-    UserHoldMohAudioSourceId       string `json:"userHoldMohAudioSourceId"`
-    AutomatedAlternateRoutingCssName string `json:"automatedAlternateRoutingCssName"`
-    AarNeighborhoodName            string `json:"aarNeighborhoodName"`
-    LoadInformation                struct {
-        Special bool   `json:"special"`
-        Value   string `json:"value"`
-    } `json:"loadInformation"`
-    VersionStamp                   string `json:"versionStamp"`
-    TraceFlag                      bool   `json:"traceFlag"`
-    MlppDomainId                   string `json:"mlppDomainId"`
-    MlppIndicationStatus           string `json:"mlppIndicationStatus"`
-    Preemption                     string `json:"preemption"`
-    UseTrustedRelayPoint           string `json:"useTrustedRelayPoint"`
-    RetryVideoCallAsAudio          *bool   `json:"retryVideoCallAsAudio"`
-    SecurityProfileName            string `json:"securityProfileName"`
-    SipProfileName                 string `json:"sipProfileName"`
-    CgpnTransformationCssName      string `json:"cgpnTransformationCssName"`
-    UseDevicePoolCgpnTransformCss  *bool   `json:"useDevicePoolCgpnTransformCss"`
-    GeoLocationName                string `json:"geoLocationName"`
-    GeoLocationFilterName          string `json:"geoLocationFilterName"`
-    SendGeoLocation                bool   `json:"sendGeoLocation"`
-    Lines                          struct {
-        Line []struct {
-            Index           int    `json:"index"`
-            Dirn            struct {
-                Pattern            string `json:"pattern"`
-                RoutePartitionName string `json:"routePartitionName"`
-            } `json:"dirn"`
-            Label               string `json:"label"`
-            Display             string `json:"display"`
-            DisplayAscii        string `json:"displayAscii"`
-            E164Mask            string `json:"e164Mask"`
-            DialPlanWizardId    int    `json:"dialPlanWizardId"`
-            MwlPolicy           string `json:"mwlPolicy"`
-            MaxNumCalls         int    `json:"maxNumCalls"`
-            BusyTrigger         int    `json:"busyTrigger"`
-            CallInfoDisplay     struct {
-                CallerName       *bool `json:"callerName"`
-                CallerNumber     bool `json:"callerNumber"`
-                RedirectedNumber bool `json:"redirectedNumber"`
-                DialedNumber     *bool `json:"dialedNumber"`
-            } `json:"callInfoDisplay"`
-            RecordingProfileName string `json:"recordingProfileName"`
-            MonitoringCssName    string `json:"monitoringCssName"`
-            RecordingFlag        string `json:"recordingFlag"`
-            AudibleMwi           string `json:"audibleMwi"`
-            SpeedDial            string `json:"speedDial"`
-            PartitionUsage       string `json:"partitionUsage"`
-            AssociatedEndusers   struct {
-                Enduser []struct {
-                    UserId string `json:"userId"`
-                } `json:"enduser"`
-            } `json:"associatedEndusers"`
-            MissedCallLogging    *bool   `json:"missedCallLogging"`
-            RecordingMediaSource string `json:"recordingMediaSource"`
-        } `json:"line"`
-    } `json:"lines"`
-    NumberOfButtons                 int    `json:"numberOfButtons"`
-    PhoneTemplateName               string `json:"phoneTemplateName"`
-    Speeddials                      []string `json:"speeddials"`
-    BusyLampFields                  []string `json:"busyLampFields"`
-    PrimaryPhoneName                string `json:"primaryPhoneName"`
-    RingSettingIdleBlfAudibleAlert  string `json:"ringSettingIdleBlfAudibleAlert"`
-    RingSettingBusyBlfAudibleAlert  string `json:"ringSettingBusyBlfAudibleAlert"`
-    BlfDirectedCallParks            []string `json:"blfDirectedCallParks"`
-    AddOnModules                    []string `json:"addOnModules"`
-    UserLocale                      string `json:"userLocale"`
-    NetworkLocale                   string `json:"networkLocale"`
-    IdleTimeout                     int    `json:"idleTimeout"`
-    AuthenticationUrl               string `json:"authenticationUrl"`
-    DirectoryUrl                    string `json:"directoryUrl"`
-    IdleUrl                         string `json:"idleUrl"`
-    InformationUrl                  string `json:"informationUrl"`
-    MessagesUrl                     string `json:"messagesUrl"`
-    ProxyServerUrl                  string `json:"proxyServerUrl"`
-    ServicesUrl                     string `json:"servicesUrl"`
-    Services                        []string `json:"services"`
-    SoftkeyTemplateName             string `json:"softkeyTemplateName"`
-    DefaultProfileName              string `json:"defaultProfileName"`
-    EnableExtensionMobility         int    `json:"enableExtensionMobility"`
-    SingleButtonBarge               string `json:"singleButtonBarge"`
-    JoinAcrossLines                 string `json:"joinAcrossLines"`
-    BuiltInBridgeStatus             string `json:"builtInBridgeStatus"`
-    CallInfoPrivacyStatus           string `json:"callInfoPrivacyStatus"`
-    HlogStatus                      string `json:"hlogStatus"`
-    OwnerUserName                   string `json:"ownerUserName"`
-    IgnorePresentationIndicators    bool   `json:"ignorePresentationIndicators"`
-    PacketCaptureMode               string `json:"packetCaptureMode"`
-    PacketCaptureDuration           int    `json:"packetCaptureDuration"`
-    SubscribeCallingSearchSpaceName string `json:"subscribeCallingSearchSpaceName"`
-    RerouteCallingSearchSpaceName   string `json:"rerouteCallingSearchSpaceName"`
-    AllowCtiControlFlag             *bool   `json:"allowCtiControlFlag"`
-    PresenceGroupName               string `json:"presenceGroupName"`
-    UnattendedPort                  bool   `json:"unattendedPort"`
-    RequireDtmfReception            bool   `json:"requireDtmfReception"`
-    Rfc2833Disabled                 bool   `json:"rfc2833Disabled"`
-    CertificateOperation            string `json:"certificateOperation"`
-    DeviceMobilityMode              string `json:"deviceMobilityMode"`
-    RemoteDevice                    bool   `json:"remoteDevice"`
-    DndOption                       string `json:"dndOption"`
-    DndStatus                       bool   `json:"dndStatus"`
-    IsActive                        bool   `json:"isActive"`
-    IsDualMode                      bool   `json:"isDualMode"`
-    PhoneSuite                      string `json:"phoneSuite"`
-    PhoneServiceDisplay             string `json:"phoneServiceDisplay"`
-    IsProtected                     bool   `json:"isProtected"`
-    MtpRequired                     bool   `json:"mtpRequired"`
-    MtpPreferedCodec                string `json:"mtpPreferedCodec"`
-    DialRulesName                   string `json:"dialRulesName"`
-    SshUserId                       string `json:"sshUserId"`
-    DigestUser                      string `json:"digestUser"`
-    OutboundCallRollover            string `json:"outboundCallRollover"`
-    HotlineDevice                   bool   `json:"hotlineDevice"`
-    SecureInformationUrl            string `json:"secureInformationUrl"`
-    SecureDirectoryUrl              string `json:"secureDirectoryUrl"`
-    SecureMessageUrl                string `json:"secureMessageUrl"`
-    SecureServicesUrl               string `json:"secureServicesUrl"`
-    SecureAuthenticationUrl         string `json:"secureAuthenticationUrl"`
-    SecureIdleUrl                   string `json:"secureIdleUrl"`
-    AlwaysUsePrimeLine              *bool   `json:"alwaysUsePrimeLine"`
-    AlwaysUsePrimeLineForVoiceMessage *bool `json:"alwaysUsePrimeLineForVoiceMessage"`
-    FeatureControlPolicy            string `json:"featureControlPolicy"`
-    DeviceTrustMode                 string `json:"deviceTrustMode"`
-    ConfidentialAccess              struct {
-        ConfidentialAccessMode string `json:"confidentialAccessMode"`
-        ConfidentialAccessLevel string `json:"confidentialAccessLevel"`
-    } `json:"confidentialAccess"`
-    RequireOffPremiseLocation       bool   `json:"requireOffPremiseLocation"`
-    CgpnIngressDN                   string `json:"cgpnIngressDN"`
-    UseDevicePoolCgpnIngressDN      *bool   `json:"useDevicePoolCgpnIngressDN"`
-    Msisdn                          string `json:"msisdn"`
-    EnableCallRoutingToRdWhenNoneIsActive bool `json:"enableCallRoutingToRdWhenNoneIsActive"`
-    WifiHotspotProfile              string `json:"wifiHotspotProfile"`
-    WirelessLanProfileGroup         string `json:"wirelessLanProfileGroup"`
-    ElinGroup                       string `json:"elinGroup"`
+    Name                                  string                `json:"name" xml:"name" validate:"required,devicename"`
+    Description                           string                `json:"description" xml:"description,omitempty"`
+    Product                               string                `json:"product" xml:"product,omitempty" validate:"required"`
+    Class                                 string                `json:"class" xml:"class,omitempty" validate:"required"`
+    Protocol                              string                `json:"protocol" xml:"protocol,omitempty" validate:"required,oneof=SCCP|SIP|H.225|Unknown"`
+    ProtocolSide                          string                `json:"protocolSide" xml:"protocolSide,omitempty" validate:"oneof=User|Network"`
+    CallingSearchSpaceName                string                `json:"callingSearchSpaceName" xml:"callingSearchSpaceName,omitempty"`
+    DevicePoolName                        string                `json:"devicePoolName" xml:"devicePoolName,omitempty"`
+    CommonDeviceConfigName                string                `json:"commonDeviceConfigName" xml:"commonDeviceConfigName,omitempty"`
+    CommonPhoneConfigName                 string                `json:"commonPhoneConfigName" xml:"commonPhoneConfigName,omitempty"`
+    NetworkLocation                       string                `json:"networkLocation" xml:"networkLocation,omitempty"`
+    LocationName                          string                `json:"locationName" xml:"locationName,omitempty"`
+    MediaResourceListName                 string                `json:"mediaResourceListName" xml:"mediaResourceListName,omitempty"`
+    NetworkHoldMohAudioSourceId           string                `json:"networkHoldMohAudioSourceId" xml:"networkHoldMohAudioSourceId,omitempty"`
+    UserHoldMohAudioSourceId              string                `json:"userHoldMohAudioSourceId" xml:"userHoldMohAudioSourceId,omitempty"`
+    AutomatedAlternateRoutingCssName      string                `json:"automatedAlternateRoutingCssName" xml:"automatedAlternateRoutingCssName,omitempty"`
+    AarNeighborhoodName                   string                `json:"aarNeighborhoodName" xml:"aarNeighborhoodName,omitempty"`
+    LoadInformation                       *LoadInformation      `json:"loadInformation" xml:"loadInformation,omitempty"`
+    VersionStamp                          string                `json:"versionStamp" xml:"versionStamp,omitempty"`
+    TraceFlag                             *bool                 `json:"traceFlag" xml:"traceFlag,omitempty"`
+    MlppDomainId                          string                `json:"mlppDomainId" xml:"mlppDomainId,omitempty"`
+    MlppIndicationStatus                  string                `json:"mlppIndicationStatus" xml:"mlppIndicationStatus,omitempty"`
+    Preemption                            string                `json:"preemption" xml:"preemption,omitempty"`
+    UseTrustedRelayPoint                  string                `json:"useTrustedRelayPoint" xml:"useTrustedRelayPoint,omitempty"`
+    RetryVideoCallAsAudio                 *bool                 `json:"retryVideoCallAsAudio" xml:"retryVideoCallAsAudio,omitempty"`
+    SecurityProfileName                   string                `json:"securityProfileName" xml:"securityProfileName,omitempty"`
+    SipProfileName                        string                `json:"sipProfileName" xml:"sipProfileName,omitempty"`
+    CgpnTransformationCssName             string                `json:"cgpnTransformationCssName" xml:"cgpnTransformationCssName,omitempty"`
+    UseDevicePoolCgpnTransformCss         *bool                 `json:"useDevicePoolCgpnTransformCss" xml:"useDevicePoolCgpnTransformCss,omitempty"`
+    GeoLocationName                       string                `json:"geoLocationName" xml:"geoLocationName,omitempty"`
+    GeoLocationFilterName                 string                `json:"geoLocationFilterName" xml:"geoLocationFilterName,omitempty"`
+    SendGeoLocation                       *bool                 `json:"sendGeoLocation" xml:"sendGeoLocation,omitempty"`
+    Lines                                 *Lines                `json:"lines" xml:"lines,omitempty"`
+    NumberOfButtons                       int                   `json:"numberOfButtons" xml:"numberOfButtons,omitempty"`
+    PhoneTemplateName                     string                `json:"phoneTemplateName" xml:"phoneTemplateName,omitempty"`
+    Speeddials                            *Speeddials           `json:"speeddials" xml:"speeddials,omitempty"`
+    BusyLampFields                        *BusyLampFields       `json:"busyLampFields" xml:"busyLampFields,omitempty"`
+    PrimaryPhoneName                      string                `json:"primaryPhoneName" xml:"primaryPhoneName,omitempty"`
+    RingSettingIdleBlfAudibleAlert        string                `json:"ringSettingIdleBlfAudibleAlert" xml:"ringSettingIdleBlfAudibleAlert,omitempty"`
+    RingSettingBusyBlfAudibleAlert        string                `json:"ringSettingBusyBlfAudibleAlert" xml:"ringSettingBusyBlfAudibleAlert,omitempty"`
+    BlfDirectedCallParks                  *BlfDirectedCallParks `json:"blfDirectedCallParks" xml:"blfDirectedCallParks,omitempty"`
+    AddOnModules                          *AddOnModules         `json:"addOnModules" xml:"addOnModules,omitempty"`
+    UserLocale                            string                `json:"userLocale" xml:"userLocale,omitempty"`
+    NetworkLocale                         string                `json:"networkLocale" xml:"networkLocale,omitempty"`
+    IdleTimeout                           int                   `json:"idleTimeout" xml:"idleTimeout,omitempty"`
+    AuthenticationUrl                     string                `json:"authenticationUrl" xml:"authenticationUrl,omitempty"`
+    DirectoryUrl                          string                `json:"directoryUrl" xml:"directoryUrl,omitempty"`
+    IdleUrl                               string                `json:"idleUrl" xml:"idleUrl,omitempty"`
+    InformationUrl                        string                `json:"informationUrl" xml:"informationUrl,omitempty"`
+    MessagesUrl                           string                `json:"messagesUrl" xml:"messagesUrl,omitempty"`
+    ProxyServerUrl                        string                `json:"proxyServerUrl" xml:"proxyServerUrl,omitempty"`
+    ServicesUrl                           string                `json:"servicesUrl" xml:"servicesUrl,omitempty"`
+    Services                              *Services             `json:"services" xml:"services,omitempty"`
+    SoftkeyTemplateName                   string                `json:"softkeyTemplateName" xml:"softkeyTemplateName,omitempty"`
+    DefaultProfileName                    string                `json:"defaultProfileName" xml:"defaultProfileName,omitempty"`
+    EnableExtensionMobility               *bool                 `json:"enableExtensionMobility" xml:"enableExtensionMobility,omitempty"`
+    SingleButtonBarge                     string                `json:"singleButtonBarge" xml:"singleButtonBarge,omitempty"`
+    JoinAcrossLines                       string                `json:"joinAcrossLines" xml:"joinAcrossLines,omitempty"`
+    BuiltInBridgeStatus                   string                `json:"builtInBridgeStatus" xml:"builtInBridgeStatus,omitempty"`
+    CallInfoPrivacyStatus                 string                `json:"callInfoPrivacyStatus" xml:"callInfoPrivacyStatus,omitempty"`
+    HlogStatus                            string                `json:"hlogStatus" xml:"hlogStatus,omitempty"`
+    OwnerUserName                         string                `json:"ownerUserName" xml:"ownerUserName,omitempty"`
+    IgnorePresentationIndicators          *bool                 `json:"ignorePresentationIndicators" xml:"ignorePresentationIndicators,omitempty"`
+    PacketCaptureMode                     string                `json:"packetCaptureMode" xml:"packetCaptureMode,omitempty"`
+    PacketCaptureDuration                 int                   `json:"packetCaptureDuration" xml:"packetCaptureDuration,omitempty"`
+    SubscribeCallingSearchSpaceName       string                `json:"subscribeCallingSearchSpaceName" xml:"subscribeCallingSearchSpaceName,omitempty"`
+    RerouteCallingSearchSpaceName         string                `json:"rerouteCallingSearchSpaceName" xml:"rerouteCallingSearchSpaceName,omitempty"`
+    AllowCtiControlFlag                   *bool                 `json:"allowCtiControlFlag" xml:"allowCtiControlFlag,omitempty"`
+    PresenceGroupName                     string                `json:"presenceGroupName" xml:"presenceGroupName,omitempty"`
+    UnattendedPort                        *bool                 `json:"unattendedPort" xml:"unattendedPort,omitempty"`
+    RequireDtmfReception                  *bool                 `json:"requireDtmfReception" xml:"requireDtmfReception,omitempty"`
+    Rfc2833Disabled                       *bool                 `json:"rfc2833Disabled" xml:"rfc2833Disabled,omitempty"`
+    CertificateOperation                  string                `json:"certificateOperation" xml:"certificateOperation,omitempty"`
+    DeviceMobilityMode                    string                `json:"deviceMobilityMode" xml:"deviceMobilityMode,omitempty"`
+    RemoteDevice                          *bool                 `json:"remoteDevice" xml:"remoteDevice,omitempty"`
+    DndOption                             string                `json:"dndOption" xml:"dndOption,omitempty" validate:"oneof=Ringer Off|Call Reject|Use Common Phone Profile Setting"`
+    DndStatus                             *bool                 `json:"dndStatus" xml:"dndStatus,omitempty"`
+    IsActive                              *bool                 `json:"isActive" xml:"isActive,omitempty"`
+    IsDualMode                            *bool                 `json:"isDualMode" xml:"isDualMode,omitempty"`
+    PhoneSuite                            string                `json:"phoneSuite" xml:"phoneSuite,omitempty"`
+    PhoneServiceDisplay                   string                `json:"phoneServiceDisplay" xml:"phoneServiceDisplay,omitempty"`
+    IsProtected                           *bool                 `json:"isProtected" xml:"isProtected,omitempty"`
+    MtpRequired                           *bool                 `json:"mtpRequired" xml:"mtpRequired,omitempty"`
+    MtpPreferedCodec                      string                `json:"mtpPreferedCodec" xml:"mtpPreferedCodec,omitempty"`
+    DialRulesName                         string                `json:"dialRulesName" xml:"dialRulesName,omitempty"`
+    SshUserId                             string                `json:"sshUserId" xml:"sshUserId,omitempty"`
+    DigestUser                            string                `json:"digestUser" xml:"digestUser,omitempty"`
+    OutboundCallRollover                  string                `json:"outboundCallRollover" xml:"outboundCallRollover,omitempty"`
+    HotlineDevice                         *bool                 `json:"hotlineDevice" xml:"hotlineDevice,omitempty"`
+    SecureInformationUrl                  string                `json:"secureInformationUrl" xml:"secureInformationUrl,omitempty"`
+    SecureDirectoryUrl                    string                `json:"secureDirectoryUrl" xml:"secureDirectoryUrl,omitempty"`
+    SecureMessageUrl                      string                `json:"secureMessageUrl" xml:"secureMessageUrl,omitempty"`
+    SecureServicesUrl                     string                `json:"secureServicesUrl" xml:"secureServicesUrl,omitempty"`
+    SecureAuthenticationUrl               string                `json:"secureAuthenticationUrl" xml:"secureAuthenticationUrl,omitempty"`
+    SecureIdleUrl                         string                `json:"secureIdleUrl" xml:"secureIdleUrl,omitempty"`
+    AlwaysUsePrimeLine                    *bool                 `json:"alwaysUsePrimeLine" xml:"alwaysUsePrimeLine,omitempty"`
+    AlwaysUsePrimeLineForVoiceMessage     *bool                 `json:"alwaysUsePrimeLineForVoiceMessage" xml:"alwaysUsePrimeLineForVoiceMessage,omitempty"`
+    FeatureControlPolicy                  string                `json:"featureControlPolicy" xml:"featureControlPolicy,omitempty"`
+    DeviceTrustMode                       string                `json:"deviceTrustMode" xml:"deviceTrustMode,omitempty"`
+    ConfidentialAccess                    *ConfidentialAccess   `json:"confidentialAccess" xml:"confidentialAccess,omitempty" axlsince:"11.5"`
+    RequireOffPremiseLocation             *bool                 `json:"requireOffPremiseLocation" xml:"requireOffPremiseLocation,omitempty" axlsince:"11.5"`
+    CgpnIngressDN                         string                `json:"cgpnIngressDN" xml:"cgpnIngressDN,omitempty" axlsince:"11.5"`
+    UseDevicePoolCgpnIngressDN            *bool                 `json:"useDevicePoolCgpnIngressDN" xml:"useDevicePoolCgpnIngressDN,omitempty" axlsince:"11.5"`
+    Msisdn                                string                `json:"msisdn" xml:"msisdn,omitempty" axlsince:"11.5"`
+    EnableCallRoutingToRdWhenNoneIsActive *bool                 `json:"enableCallRoutingToRdWhenNoneIsActive" xml:"enableCallRoutingToRdWhenNoneIsActive,omitempty" axlsince:"11.5"`
+    WifiHotspotProfile                    string                `json:"wifiHotspotProfile" xml:"wifiHotspotProfile,omitempty" axlsince:"12.0"`
+    WirelessLanProfileGroup               string                `json:"wirelessLanProfileGroup" xml:"wirelessLanProfileGroup,omitempty" axlsince:"12.0"`
+    ElinGroup                             string                `json:"elinGroup" xml:"elinGroup,omitempty" axlsince:"12.0"`
+}
+
+// LoadInformation is the firmware load, optionally marked special
+type LoadInformation struct {
+    Special bool   `json:"special" xml:"special,attr"`
+    Value   string `json:"value" xml:",chardata"`
+}
+
+// Lines wraps the line appearances of a phone
+type Lines struct {
+    Line []Line `json:"line" xml:"line" validate:"unique=index"`
+}
+
+// Line is a single line appearance on a phone, in AXL XPhoneLine element order
+type Line struct {
+    Index                        int                 `json:"index" xml:"index" validate:"min=1"`
+    Label                        string              `json:"label" xml:"label,omitempty"`
+    Display                      string              `json:"display" xml:"display,omitempty"`
+    Dirn                         Dirn                `json:"dirn" xml:"dirn"`
+    RingSetting                  string              `json:"ringSetting" xml:"ringSetting,omitempty"`
+    ConsecutiveRingSetting       string              `json:"consecutiveRingSetting" xml:"consecutiveRingSetting,omitempty"`
+    RingSettingIdlePickupAlert   string              `json:"ringSettingIdlePickupAlert" xml:"ringSettingIdlePickupAlert,omitempty"`
+    RingSettingActivePickupAlert string              `json:"ringSettingActivePickupAlert" xml:"ringSettingActivePickupAlert,omitempty"`
+    DisplayAscii                 string              `json:"displayAscii" xml:"displayAscii,omitempty"`
+    E164Mask                     string              `json:"e164Mask" xml:"e164Mask,omitempty"`
+    DialPlanWizardId             int                 `json:"dialPlanWizardId" xml:"dialPlanWizardId,omitempty"`
+    MwlPolicy                    string              `json:"mwlPolicy" xml:"mwlPolicy,omitempty" validate:"oneof=Use System Policy|Light and Prompt|Prompt Only|Light Only|None"`
+    MaxNumCalls                  int                 `json:"maxNumCalls" xml:"maxNumCalls,omitempty" validate:"min=1,max=200"`
+    BusyTrigger                  int                 `json:"busyTrigger" xml:"busyTrigger,omitempty" validate:"min=1,max=200,lte=maxNumCalls"`
+    CallInfoDisplay              *CallInfoDisplay    `json:"callInfoDisplay" xml:"callInfoDisplay,omitempty"`
+    RecordingProfileName         string              `json:"recordingProfileName" xml:"recordingProfileName,omitempty"`
+    MonitoringCssName            string              `json:"monitoringCssName" xml:"monitoringCssName,omitempty"`
+    RecordingFlag                string              `json:"recordingFlag" xml:"recordingFlag,omitempty"`
+    AudibleMwi                   string              `json:"audibleMwi" xml:"audibleMwi,omitempty"`
+    SpeedDial                    string              `json:"speedDial" xml:"speedDial,omitempty"`
+    PartitionUsage               string              `json:"partitionUsage" xml:"partitionUsage,omitempty"`
+    AssociatedEndusers           *AssociatedEndusers `json:"associatedEndusers" xml:"associatedEndusers,omitempty"`
+    MissedCallLogging            *bool               `json:"missedCallLogging" xml:"missedCallLogging,omitempty"`
+    RecordingMediaSource         string              `json:"recordingMediaSource" xml:"recordingMediaSource,omitempty"`
+}
+
+// Dirn identifies a directory number by pattern and partition
+type Dirn struct {
+    Pattern            string `json:"pattern" xml:"pattern" validate:"required"`
+    RoutePartitionName string `json:"routePartitionName" xml:"routePartitionName,omitempty"`
+}
+
+// CallInfoDisplay controls what a line shows for incoming calls
+type CallInfoDisplay struct {
+    CallerName       *bool `json:"callerName" xml:"callerName,omitempty"`
+    CallerNumber     *bool `json:"callerNumber" xml:"callerNumber,omitempty"`
+    RedirectedNumber *bool `json:"redirectedNumber" xml:"redirectedNumber,omitempty"`
+    DialedNumber     *bool `json:"dialedNumber" xml:"dialedNumber,omitempty"`
+}
+
+// AssociatedEndusers lists the end users associated with a line
+type AssociatedEndusers struct {
+    Enduser []Enduser `json:"enduser" xml:"enduser"`
+}
+
+// Enduser is an end user reference
+type Enduser struct {
+    UserId string `json:"userId" xml:"userId"`
+}
+
+// ConfidentialAccess holds the confidential access level settings
+type ConfidentialAccess struct {
+    ConfidentialAccessMode  string `json:"confidentialAccessMode" xml:"confidentialAccessMode,omitempty"`
+    ConfidentialAccessLevel string `json:"confidentialAccessLevel" xml:"confidentialAccessLevel,omitempty"`
+}
+
+// Speeddials wraps the speed dial buttons of a phone
+type Speeddials struct {
+    Speeddial []SpeedDial `json:"speeddial" xml:"speeddial" validate:"unique=index"`
+}
+
+// SpeedDial is a speed dial button
+type SpeedDial struct {
+    Dirn  string `json:"dirn" xml:"dirn" validate:"required"`
+    Label string `json:"label" xml:"label,omitempty"`
+    Index int    `json:"index" xml:"index" validate:"min=1"`
+}
+
+// BusyLampFields wraps the BLF buttons of a phone
+type BusyLampFields struct {
+    BusyLampField []BusyLampField `json:"busyLampField" xml:"busyLampField" validate:"unique=index"`
+}
+
+// BusyLampField is a BLF button watching a directory number or SIP URI
+type BusyLampField struct {
+    BlfDest        string `json:"blfDest" xml:"blfDest,omitempty" validate:"requiredwithout=blfDirn"`
+    BlfDirn        string `json:"blfDirn" xml:"blfDirn,omitempty"`
+    RoutePartition string `json:"routePartition" xml:"routePartition,omitempty"`
+    Label          string `json:"label" xml:"label,omitempty"`
+    Index          int    `json:"index" xml:"index" validate:"min=1"`
+}
+
+// BlfDirectedCallParks wraps the directed call park BLF buttons of a phone
+type BlfDirectedCallParks struct {
+    BlfDirectedCallPark []BlfDirectedCallPark `json:"blfDirectedCallPark" xml:"blfDirectedCallPark" validate:"unique=index"`
+}
+
+// BlfDirectedCallPark is a BLF button for a directed call park number
+type BlfDirectedCallPark struct {
+    Label                          string `json:"label" xml:"label,omitempty"`
+    DirectedCallParkDnAndPartition struct {
+        DnPattern          string `json:"dnPattern" xml:"dnPattern" validate:"required"`
+        RoutePartitionName string `json:"routePartitionName" xml:"routePartitionName,omitempty"`
+    } `json:"directedCallParkDnAndPartition" xml:"directedCallParkDnAndPartition"`
+    Index int `json:"index" xml:"index" validate:"min=1"`
+}
+
+// AddOnModules wraps the key expansion modules of a phone
+type AddOnModules struct {
+    AddOnModule []AddOnModule `json:"addOnModule" xml:"addOnModule" validate:"unique=index"`
+}
+
+// AddOnModule is a key expansion module attached to the phone
+type AddOnModule struct {
+    LoadInformation *LoadInformation `json:"loadInformation" xml:"loadInformation,omitempty"`
+    Model           string           `json:"model" xml:"model" validate:"required"`
+    Index           int              `json:"index" xml:"index" validate:"min=1"`
+}
+
+// Services wraps the IP phone service subscriptions of a phone
+type Services struct {
+    Service []SubscribedService `json:"service" xml:"service"`
+}
+
+// SubscribedService is an IP phone service subscription
+type SubscribedService struct {
+    TelecasterServiceName string            `json:"telecasterServiceName" xml:"telecasterServiceName" validate:"required"`
+    Name                  string            `json:"name" xml:"name,omitempty"`
+    Url                   string            `json:"url" xml:"url,omitempty" validate:"url"`
+    UrlButtonIndex        int               `json:"urlButtonIndex" xml:"urlButtonIndex,omitempty"`
+    UrlLabel              string            `json:"urlLabel" xml:"urlLabel,omitempty"`
+    ServiceNameAscii      string            `json:"serviceNameAscii" xml:"serviceNameAscii,omitempty"`
+    Parameters            map[string]string `json:"parameters" xml:"-"`
+}
+
+// AddPhoneAXLReq structure for SOAP request
+type AddPhoneAXLReq struct {
+    XMLName xml.Name     `xml:"axl:addPhone"`
+    Phone   *AddPhoneReq `xml:"phone"`
 }
 
 // AddPhoneResp structure for SOAP response
@@ -222,404 +330,217 @@ type AddPhoneResp struct {
 }
 
 
-func boolToIntStringPtr(value *bool) string {
-    if value == nil {
-        return ""
+// What a lines value must look like, for decode errors
+const linesExpected = `a list of lines or {"line": [...]}`
+
+// Function to accept lines either as {"line": [...]} or as a bare array
+func (l *Lines) UnmarshalJSON(data []byte) error {
+    if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+        if err := json.Unmarshal(data, &l.Line); err != nil {
+            return &NestedDecodeError{Key: "lines", Expected: linesExpected, Err: err}
+        }
+        return nil
     }
-    if *value {
-        return "1"
+
+    type plain Lines
+    if err := json.Unmarshal(data, (*plain)(l)); err != nil {
+        return &NestedDecodeError{Key: "lines", Expected: linesExpected, Err: err}
     }
-    return "0"
+    return nil
 }
 
-
-func boolToString(value bool) string {
-    if value {
-        return "true"
+// Function to number unindexed lines with the lowest free index
+func (l *Lines) normalize() {
+    if l != nil {
+        assignIndexes(len(l.Line), func(i int) *int { return &l.Line[i].Index })
     }
-    return "false"
 }
 
-func main() {
-        http.HandleFunc("/addPhone", handleAddPhoneRequest)
-        http.HandleFunc("/listUsers", handleListUsersRequest)
+// Function to fill in the lines, buttons and service URLs of a validated phone before it is sent to AXL
+func (p *AddPhoneReq) normalize() {
+    p.Lines.normalize()
 
-        // Generate or specify your SSL certificates
-        certFile := "./server.crt"
-        keyFile := "./server.key"
+    if p.Speeddials != nil {
+        sds := p.Speeddials.Speeddial
+        assignIndexes(len(sds), func(i int) *int { return &sds[i].Index })
+    }
+    if p.BusyLampFields != nil {
+        blfs := p.BusyLampFields.BusyLampField
+        assignIndexes(len(blfs), func(i int) *int { return &blfs[i].Index })
+    }
+    if p.BlfDirectedCallParks != nil {
+        parks := p.BlfDirectedCallParks.BlfDirectedCallPark
+        assignIndexes(len(parks), func(i int) *int { return &parks[i].Index })
+    }
+    if p.AddOnModules != nil {
+        kems := p.AddOnModules.AddOnModule
+        assignIndexes(len(kems), func(i int) *int { return &kems[i].Index })
+    }
 
-        log.Println("Starting server on :8443")
-        err := http.ListenAndServeTLS(":8443", certFile, keyFile, nil)
-        if err != nil {
-                log.Fatalf("Server failed to start: %v", err)
+    if p.Services != nil {
+        for i := range p.Services.Service {
+            svc := &p.Services.Service[i]
+            if len(svc.Parameters) == 0 {
+                continue
+            }
+            // the url rule has already rejected a url that does not parse
+            u, _ := url.Parse(svc.Url)
+            q := u.Query()
+            for k, v := range svc.Parameters {
+                q.Set(k, v)
+            }
+            u.RawQuery = q.Encode()
+            svc.Url = u.String()
+        }
+    }
+}
+
+// Function to give unindexed items the lowest free index; the unique and min rules have already
+// rejected repeated and negative indexes
+func assignIndexes(n int, index func(i int) *int) {
+    used := make(map[int]bool, n)
+    for i := 0; i < n; i++ {
+        used[*index(i)] = true
+    }
+
+    next := 1
+    for i := 0; i < n; i++ {
+        if idx := index(i); *idx == 0 {
+            for used[next] {
+                next++
+            }
+            *idx = next
+            used[next] = true
         }
+    }
 }
 
-// Handler function for listing users
-func handleListUsersRequest(w http.ResponseWriter, r *http.Request) {
-        // Create the SOAP request
-        soapRequest := `
-<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:axl="http://www.cisco.com/AXL/API/14.0">
-   <soapenv:Header/>
-   <soapenv:Body>
-      <axl:executeSQLQuery>
-         <sql>SELECT userid, firstname, lastname, department FROM enduser</sql>
-      </axl:executeSQLQuery>
-   </soapenv:Body>
-</soapenv:Envelope>`
+// Page size limits for list endpoints, kept well under the AXL memory limit
+const (
+        defaultPageSize = 200
+        maxPageSize     = 2000
+)
 
-        // Forward the request to Cisco AXL API
-        response, err := sendAXLRequest(soapRequest)
+func main() {
+        configFile := flag.String("config", "", "path to a YAML or TOML config file (default $CMGATOR_CONFIG or ./"+defaultConfigFile+")")
+        flag.Usage = func() {
+                fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-config file] [report location [-format json|csv] [-location name] [-cluster name|all] | trust [-cluster name|all] [-replace]]\n", os.Args[0])
+                flag.PrintDefaults()
+        }
+        flag.Parse()
+
+        // with no command the REST server is started; otherwise the command runs once and exits
+        args := flag.Args()
+        serve := len(args) == 0
+
+        cfg, err := loadConfig(*configFile, serve)
         if err != nil {
-                http.Error(w, "Failed to forward request", http.StatusInternalServerError)
-                logResponse("error", err.Error(), nil)
-                return
+                log.Fatalf("Failed to load configuration: %v", err)
         }
+        config = cfg
 
-        // Parse the SOAP response
-        var resp ExecuteSQLQueryResp
-        if err := xml.Unmarshal(response, &resp); err != nil {
-                http.Error(w, "Failed to parse response", http.StatusInternalServerError)
-                logResponse("error", err.Error(), nil)
+        if !serve {
+                if err := runCommand(args); err != nil {
+                        log.Fatalf("%s: %v", args[0], err)
+                }
                 return
         }
 
-        // Extract user information
-        users := make([]map[string]string, len(resp.Body.ExecuteSQLQueryResponse.Return.Rows))
-        for i, row := range resp.Body.ExecuteSQLQueryResponse.Return.Rows {
-                users[i] = map[string]string{
-                        "userid":     row.UserID,
-                        "firstname":  row.FirstName,
-                        "lastname":   row.LastName,
-                        "department": row.Department,
+        resolveAXLVersions()
+
+        mux := http.NewServeMux()
+        mux.HandleFunc("/addPhone", handleAddPhoneRequest)
+        mux.HandleFunc("/listUsers", handleListUsersRequest)
+        mux.HandleFunc("/addUser", handleAddUserRequest)
+        mux.HandleFunc("/associatePhone", handleAssociatePhoneRequest)
+        mux.HandleFunc("/getUser", handleGetUserRequest)
+        mux.HandleFunc("/phones", handlePhonesRequest)
+        mux.HandleFunc("/phones/", handlePhoneRequest)
+        mux.HandleFunc("/lines", handleLinesRequest)
+        mux.HandleFunc("/lines/", handleLineRequest)
+        mux.HandleFunc("/reports/location", handleLocationReportRequest)
+        mux.HandleFunc("/sql", handleSQLQueryRequest)
+        mux.HandleFunc("/sql/update", handleSQLUpdateRequest)
+        mux.HandleFunc("/clusters", handleClustersRequest)
+        mux.HandleFunc("/", handleUnknownPath)
+
+        for _, cl := range allClusters() {
+                log.Printf("Cluster %s: AXL %s at %s", cl.Name, cl.AXL.Version, cl.AXL.Host)
+                if cl.AXL.TLS.InsecureSkipVerify {
+                        log.Printf("Cluster %s: WARNING: certificates of unpinned nodes are not verified (axl.tls.insecureSkipVerify)", cl.Name)
                 }
         }
+        log.Printf("Starting server on %s (default cluster %s)", config.Listen.Addr, config.DefaultCluster)
+        err = http.ListenAndServeTLS(config.Listen.Addr, config.Listen.CertFile, config.Listen.KeyFile, requestIDRouter(clusterRouter(priorityRouter(mux))))
+        if err != nil {
+                log.Fatalf("Server failed to start: %v", err)
+        }
+}
 
-        // Write the JSON response back to the client
-        jsonResponse(w, http.StatusOK, "Users retrieved successfully", users)
+// Function to run a command-line subcommand instead of the server
+func runCommand(args []string) error {
+        switch args[0] {
+        case "report":
+                resolveAXLVersions()
+                return runReportCommand(args[1:])
+        case "trust":
+                // trust runs without version detection, which needs the certificates it pins
+                return runTrustCommand(args[1:])
+        }
+        return fmt.Errorf("unknown command (see -h)")
 }
 
 func handleAddPhoneRequest(w http.ResponseWriter, r *http.Request) {
     var req AddPhoneReq
-    err := json.NewDecoder(r.Body).Decode(&req)
-    if err != nil {
-        http.Error(w, "Invalid request", http.StatusBadRequest)
-        logResponse("error", "Invalid request", nil)
+    if err := decodeJSON(r, &req); err != nil {
+        decodeErrorResponse(w, err)
+        return
+    }
+
+    if err := validateRequest(&req, false); err != nil {
+        validationErrorResponse(w, err)
         return
     }
 
-    retryVideoCallAsAudioStr := boolToIntStringPtr(req.RetryVideoCallAsAudio)
-    useDevicePoolCgpnTransformCssStr := boolToIntStringPtr(req.UseDevicePoolCgpnTransformCss)
-    allowCtiControlFlagStr := boolToIntStringPtr(req.AllowCtiControlFlag)
-    callerNameStr := boolToIntStringPtr(req.Lines.Line[0].CallInfoDisplay.CallerName)
-    dialedNumberStr := boolToIntStringPtr(req.Lines.Line[0].CallInfoDisplay.DialedNumber)
-    missedCallLoggingStr := boolToIntStringPtr(req.Lines.Line[0].MissedCallLogging)
-    alwaysUsePrimeLineStr := boolToIntStringPtr(req.AlwaysUsePrimeLine)
-    alwaysUsePrimeLineForVoiceMessageStr := boolToIntStringPtr(req.AlwaysUsePrimeLineForVoiceMessage)
-    useDevicePoolCgpnIngressDNStr := boolToIntStringPtr(req.UseDevicePoolCgpnIngressDN)
-
-    // Construct the SOAP request with the converted values
-    soapRequest := fmt.Sprintf(`
-<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:axl="http://www.cisco.com/AXL/API/14.0">
-   <soapenv:Header/>
-   <soapenv:Body>
-      <axl:addPhone>
-         <phone>
-            <name>%s</name>
-            <description>%s</description>
-            <product>%s</product>
-            <class>%s</class>
-            <protocol>%s</protocol>
-            <protocolSide>%s</protocolSide>
-            <callingSearchSpaceName>%s</callingSearchSpaceName>
-            <devicePoolName>%s</devicePoolName>
-            <commonDeviceConfigName>%s</commonDeviceConfigName>
-            <commonPhoneConfigName>%s</commonPhoneConfigName>
-            <networkLocation>%s</networkLocation>
-            <locationName>%s</locationName>
-            <mediaResourceListName>%s</mediaResourceListName>
-            <networkHoldMohAudioSourceId>%s</networkHoldMohAudioSourceId>
-            <userHoldMohAudioSourceId>%s</userHoldMohAudioSourceId>
-            <automatedAlternateRoutingCssName>%s</automatedAlternateRoutingCssName>
-            <aarNeighborhoodName>%s</aarNeighborhoodName>
-            <loadInformation special="%s">%s</loadInformation>
-            <vendorConfig/>
-            <versionStamp>%s</versionStamp>
-            <traceFlag>%t</traceFlag>
-            <mlppDomainId>%s</mlppDomainId>
-            <mlppIndicationStatus>%s</mlppIndicationStatus>
-            <preemption>%s</preemption>
-            <useTrustedRelayPoint>%s</useTrustedRelayPoint>
-            <retryVideoCallAsAudio>%s</retryVideoCallAsAudio>
-            <securityProfileName>%s</securityProfileName>
-            <sipProfileName>%s</sipProfileName>
-            <cgpnTransformationCssName>%s</cgpnTransformationCssName>
-            <useDevicePoolCgpnTransformCss>%s</useDevicePoolCgpnTransformCss>
-            <geoLocationName>%s</geoLocationName>
-            <geoLocationFilterName>%s</geoLocationFilterName>
-            <sendGeoLocation>%t</sendGeoLocation>
-            <lines>
-               <line>
-                  <index>%d</index>
-                  <dirn>
-                     <pattern>%s</pattern>
-                     <routePartitionName>%s</routePartitionName>
-                  </dirn>
-                  <label>%s</label>
-                  <display>%s</display>
-                  <displayAscii>%s</displayAscii>
-                  <e164Mask>%s</e164Mask>
-                  <dialPlanWizardId>%d</dialPlanWizardId>
-                  <mwlPolicy>%s</mwlPolicy>
-                  <maxNumCalls>%d</maxNumCalls>
-                  <busyTrigger>%d</busyTrigger>
-                  <callInfoDisplay>
-                     <callerName>%s</callerName>
-                     <callerNumber>%t</callerNumber>
-                     <redirectedNumber>%t</redirectedNumber>
-                     <dialedNumber>%s</dialedNumber>
-                  </callInfoDisplay>
-                  <recordingProfileName>%s</recordingProfileName>
-                  <monitoringCssName>%s</monitoringCssName>
-                  <recordingFlag>%s</recordingFlag>
-                  <audibleMwi>%s</audibleMwi>
-                  <speedDial>%s</speedDial>
-                  <partitionUsage>%s</partitionUsage>
-                  <associatedEndusers>
-                     <enduser>
-                        <userId>%s</userId>
-                     </enduser>
-                  </associatedEndusers>
-                  <missedCallLogging>%s</missedCallLogging>
-                  <recordingMediaSource>%s</recordingMediaSource>
-               </line>
-            </lines>
-            <numberOfButtons>%d</numberOfButtons>
-            <phoneTemplateName>%s</phoneTemplateName>
-            <speeddials/>
-            <busyLampFields/>
-            <primaryPhoneName>%s</primaryPhoneName>
-            <ringSettingIdleBlfAudibleAlert>%s</ringSettingIdleBlfAudibleAlert>
-            <ringSettingBusyBlfAudibleAlert>%s</ringSettingBusyBlfAudibleAlert>
-            <blfDirectedCallParks/>
-            <addOnModules/>
-            <userLocale>%s</userLocale>
-            <networkLocale>%s</networkLocale>
-            <idleTimeout>%d</idleTimeout>
-            <authenticationUrl>%s</authenticationUrl>
-            <directoryUrl>%s</directoryUrl>
-            <idleUrl>%s</idleUrl>
-            <informationUrl>%s</informationUrl>
-            <messagesUrl>%s</messagesUrl>
-            <proxyServerUrl>%s</proxyServerUrl>
-            <servicesUrl>%s</servicesUrl>
-            <services/>
-            <softkeyTemplateName>%s</softkeyTemplateName>
-            <defaultProfileName>%s</defaultProfileName>
-            <enableExtensionMobility>%d</enableExtensionMobility>
-            <singleButtonBarge>%s</singleButtonBarge>
-            <joinAcrossLines>%s</joinAcrossLines>
-            <builtInBridgeStatus>%s</builtInBridgeStatus>
-            <callInfoPrivacyStatus>%s</callInfoPrivacyStatus>
-            <hlogStatus>%s</hlogStatus>
-            <ownerUserName>%s</ownerUserName>
-            <ignorePresentationIndicators>%t</ignorePresentationIndicators>
-            <packetCaptureMode>%s</packetCaptureMode>
-            <packetCaptureDuration>%d</packetCaptureDuration>
-            <subscribeCallingSearchSpaceName>%s</subscribeCallingSearchSpaceName>
-            <rerouteCallingSearchSpaceName>%s</rerouteCallingSearchSpaceName>
-            <allowCtiControlFlag>%s</allowCtiControlFlag>
-            <presenceGroupName>%s</presenceGroupName>
-            <unattendedPort>%t</unattendedPort>
-            <requireDtmfReception>%t</requireDtmfReception>
-            <rfc2833Disabled>%t</rfc2833Disabled>
-            <certificateOperation>%s</certificateOperation>
-            <deviceMobilityMode>%s</deviceMobilityMode>
-            <remoteDevice>%t</remoteDevice>
-            <dndOption>%s</dndOption>
-            <dndStatus>%t</dndStatus>
-            <isActive>%t</isActive>
-            <isDualMode>%t</isDualMode>
-            <phoneSuite>%s</phoneSuite>
-            <phoneServiceDisplay>%s</phoneServiceDisplay>
-            <isProtected>%t</isProtected>
-            <mtpRequired>%t</mtpRequired>
-            <mtpPreferedCodec>%s</mtpPreferedCodec>
-            <dialRulesName>%s</dialRulesName>
-            <sshUserId>%s</sshUserId>
-            <digestUser>%s</digestUser>
-            <outboundCallRollover>%s</outboundCallRollover>
-            <hotlineDevice>%t</hotlineDevice>
-            <secureInformationUrl>%s</secureInformationUrl>
-            <secureDirectoryUrl>%s</secureDirectoryUrl>
-            <secureMessageUrl>%s</secureMessageUrl>
-            <secureServicesUrl>%s</secureServicesUrl>
-            <secureAuthenticationUrl>%s</secureAuthenticationUrl>
-            <secureIdleUrl>%s</secureIdleUrl>
-            <alwaysUsePrimeLine>%s</alwaysUsePrimeLine>
-            <alwaysUsePrimeLineForVoiceMessage>%s</alwaysUsePrimeLineForVoiceMessage>
-            <featureControlPolicy>%s</featureControlPolicy>
-            <deviceTrustMode>%s</deviceTrustMode>
-            <confidentialAccess>
-               <confidentialAccessMode>%s</confidentialAccessMode>
-               <confidentialAccessLevel>%s</confidentialAccessLevel>
-            </confidentialAccess>
-            <requireOffPremiseLocation>%t</requireOffPremiseLocation>
-            <cgpnIngressDN>%s</cgpnIngressDN>
-            <useDevicePoolCgpnIngressDN>%s</useDevicePoolCgpnIngressDN>
-            <msisdn>%s</msisdn>
-            <enableCallRoutingToRdWhenNoneIsActive>%t</enableCallRoutingToRdWhenNoneIsActive>
-            <wifiHotspotProfile>%s</wifiHotspotProfile>
-            <wirelessLanProfileGroup>%s</wirelessLanProfileGroup>
-            <elinGroup>%s</elinGroup>
-         </phone>
-      </axl:addPhone>
-   </soapenv:Body>
-</soapenv:Envelope>`,
-        req.Name,
-        req.Description,
-        req.Product,
-        req.Class,
-        req.Protocol,
-        req.ProtocolSide,
-        req.CallingSearchSpaceName,
-        req.DevicePoolName,
-        req.CommonDeviceConfigName,
-        req.CommonPhoneConfigName,
-        req.NetworkLocation,
-        req.LocationName,
-        req.MediaResourceListName,
-        req.NetworkHoldMohAudioSourceId,
-        req.UserHoldMohAudioSourceId,
-        req.AutomatedAlternateRoutingCssName,
-        req.AarNeighborhoodName,
-        boolToString(req.LoadInformation.Special),
-        req.LoadInformation.Value,
-        req.VersionStamp,
-        req.TraceFlag,
-        req.MlppDomainId,
-        req.MlppIndicationStatus,
-        req.Preemption,
-        req.UseTrustedRelayPoint,
-        retryVideoCallAsAudioStr,
-        req.SecurityProfileName,
-        req.SipProfileName,
-        req.CgpnTransformationCssName,
-        useDevicePoolCgpnTransformCssStr,
-        req.GeoLocationName,
-        req.GeoLocationFilterName,
-        req.SendGeoLocation,
-        req.Lines.Line[0].Index,
-        req.Lines.Line[0].Dirn.Pattern,
-        req.Lines.Line[0].Dirn.RoutePartitionName,
-        req.Lines.Line[0].Label,
-        req.Lines.Line[0].Display,
-        req.Lines.Line[0].DisplayAscii,
-        req.Lines.Line[0].E164Mask,
-        req.Lines.Line[0].DialPlanWizardId,
-        req.Lines.Line[0].MwlPolicy,
-        req.Lines.Line[0].MaxNumCalls,
-        req.Lines.Line[0].BusyTrigger,
-        callerNameStr,
-        req.Lines.Line[0].CallInfoDisplay.CallerNumber,
-        req.Lines.Line[0].CallInfoDisplay.RedirectedNumber,
-        dialedNumberStr,
-        req.Lines.Line[0].RecordingProfileName,
-        req.Lines.Line[0].MonitoringCssName,
-        req.Lines.Line[0].RecordingFlag,
-        req.Lines.Line[0].AudibleMwi,
-        req.Lines.Line[0].SpeedDial,
-        req.Lines.Line[0].PartitionUsage,
-        req.Lines.Line[0].AssociatedEndusers.Enduser[0].UserId,
-        missedCallLoggingStr,
-        req.Lines.Line[0].RecordingMediaSource,
-        req.NumberOfButtons,
-        req.PhoneTemplateName,
-        req.PrimaryPhoneName,
-        req.RingSettingIdleBlfAudibleAlert,
-        req.RingSettingBusyBlfAudibleAlert,
-        req.UserLocale,
-        req.NetworkLocale,
-        req.IdleTimeout,
-        req.AuthenticationUrl,
-        req.DirectoryUrl,
-        req.IdleUrl,
-        req.InformationUrl,
-        req.MessagesUrl,
-        req.ProxyServerUrl,
-        req.ServicesUrl,
-        req.SoftkeyTemplateName,
-        req.DefaultProfileName,
-        req.EnableExtensionMobility,
-        req.SingleButtonBarge,
-        req.JoinAcrossLines,
-        req.BuiltInBridgeStatus,
-        req.CallInfoPrivacyStatus,
-        req.HlogStatus,
-        req.OwnerUserName,
-        req.IgnorePresentationIndicators,
-        req.PacketCaptureMode,
-        req.PacketCaptureDuration,
-        req.SubscribeCallingSearchSpaceName,
-        req.RerouteCallingSearchSpaceName,
-        allowCtiControlFlagStr,
-        req.PresenceGroupName,
-        req.UnattendedPort,
-        req.RequireDtmfReception,
-        req.Rfc2833Disabled,
-        req.CertificateOperation,
-        req.DeviceMobilityMode,
-        req.RemoteDevice,
-        req.DndOption,
-        req.DndStatus,
-        req.IsActive,
-        req.IsDualMode,
-        req.PhoneSuite,
-        req.PhoneServiceDisplay,
-        req.IsProtected,
-        req.MtpRequired,
-        req.MtpPreferedCodec,
-        req.DialRulesName,
-        req.SshUserId,
-        req.DigestUser,
-        req.OutboundCallRollover,
-        req.HotlineDevice,
-        req.SecureInformationUrl,
-        req.SecureDirectoryUrl,
-        req.SecureMessageUrl,
-        req.SecureServicesUrl,
-        req.SecureAuthenticationUrl,
-        req.SecureIdleUrl,
-        alwaysUsePrimeLineStr,
-        alwaysUsePrimeLineForVoiceMessageStr,
-        req.FeatureControlPolicy,
-        req.DeviceTrustMode,
-        req.ConfidentialAccess.ConfidentialAccessMode,
-        req.ConfidentialAccess.ConfidentialAccessLevel,
-        req.RequireOffPremiseLocation,
-        req.CgpnIngressDN,
-        useDevicePoolCgpnIngressDNStr,
-        req.Msisdn,
-        req.EnableCallRoutingToRdWhenNoneIsActive,
-        req.WifiHotspotProfile,
-        req.WirelessLanProfileGroup,
-        req.ElinGroup)
-
-    log.Printf("Generated SOAP request: %s", soapRequest)
-
-    response, err := sendAXLRequest(soapRequest)
+    req.normalize()
+
+    var reservations []DNReservation
+    defer func() { releaseReservations(reservations) }()
+    if req.Lines != nil {
+        for i := range req.Lines.Line {
+            dirn := &req.Lines.Line[i].Dirn
+            if err := allocatePattern(r.Context(), &dirn.Pattern, &dirn.RoutePartitionName, &reservations); err != nil {
+                allocationErrorResponse(w, err)
+                return
+            }
+        }
+    }
+
+    soapRequest, err := marshalAXLRequest(r.Context(), &AddPhoneAXLReq{Phone: &req})
     if err != nil {
-        http.Error(w, "Failed to forward request", http.StatusInternalServerError)
-        logResponse("error", err.Error(), nil)
+        axlErrorResponse(w, err)
         return
     }
 
-    log.Printf("Received SOAP response: %s", string(response))
+    response, err := sendAXLRequest(r.Context(), "addPhone", soapRequest)
+    if err != nil {
+        axlErrorResponse(w, err)
+        return
+    }
 
     var resp AddPhoneResp
     if err := xml.Unmarshal(response, &resp); err != nil {
-        http.Error(w, "Failed to parse response", http.StatusInternalServerError)
-        logResponse("error", err.Error(), nil)
+        logResponse("error", err.Error())
+        errorResponse(w, http.StatusInternalServerError, "Failed to parse response", nil)
+        return
+    }
+
+    // callers that asked for "auto:<range>" need to learn which numbers they got
+    if len(reservations) > 0 {
+        jsonResponse(w, http.StatusOK, "Phone added successfully", map[string]interface{}{
+            "uuid":  resp.Body.AddPhoneResponse.Return,
+            "lines": req.Lines.Line,
+        })
         return
     }
 
@@ -632,59 +553,339 @@ func handleAddPhoneRequest(w http.ResponseWriter, r *http.Request) {
 *
 */
 
-// Function to send AXL requests
-func sendAXLRequest(soapRequest string) ([]byte, error) {
-        httpClient := &http.Client{
-                Transport: &http.Transport{
-                        TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-                },
+// Function to send an AXL request to the cluster in ctx; throttled requests are retried with backoff
+func sendAXLRequest(ctx context.Context, op, soapRequest string) ([]byte, error) {
+        cl := clusterFrom(ctx)
+        for attempt := 1; ; attempt++ {
+                body, err := sendAXLAttempt(ctx, cl, op, soapRequest, attempt)
+                if !errors.Is(err, ErrAXLThrottled) || attempt >= cl.AXL.Retry.Attempts {
+                        return body, err
+                }
+
+                // the slot is given back while waiting so other requests are not held up
+                delay := cl.AXL.Retry.backoff(attempt)
+                log.Printf("Cluster %s: %s throttled, retrying in %s (attempt %d of %d)", cl.Name, op, delay.Round(time.Millisecond), attempt+1, cl.AXL.Retry.Attempts)
+                timer := time.NewTimer(delay)
+                select {
+                case <-timer.C:
+                case <-ctx.Done():
+                        timer.Stop()
+                        return nil, err
+                }
         }
+}
 
-        req, err := http.NewRequest("POST", "https://10.10.20.1:8443/axl/", bytes.NewBuffer([]byte(soapRequest)))
+// Function to send an AXL request once; reads fail over to subscribers, writes only go to the publisher
+func sendAXLAttempt(ctx context.Context, cl *Cluster, op, soapRequest string, attempt int) ([]byte, error) {
+        nodes, err := cl.nodesFor(op)
+        if err != nil {
+                return nil, err
+        }
+
+        release, err := cl.acquire(ctx)
+        if err != nil {
+                return nil, err
+        }
+        defer release()
+
+        // a node that answers at all, even with a fault, is healthy; only nodes that cannot be connected to are skipped
+        var lastErr error
+        for _, node := range nodes {
+                start := time.Now()
+                body, reached, err := postAXLRequest(ctx, cl, node, soapRequest)
+                traceAXL(ctx, cl, node, op, attempt, start, soapRequest, body, err)
+                if reached {
+                        node.markUp()
+                        if err != nil {
+                                return nil, err
+                        }
+                        return body, nil
+                }
+                if ctx.Err() != nil {
+                        return nil, err
+                }
+                if !errors.Is(err, ErrNodeUnreachable) {
+                        // the node took the connection, so a timeout or dropped response says nothing about its health
+                        return nil, err
+                }
+                node.markDown(err, cl.AXL.HealthRetry)
+                log.Printf("Cluster %s: %s %s unreachable, skipping it for %s: %v", cl.Name, node.role(), node.Host, cl.AXL.HealthRetry, err)
+                lastErr = err
+        }
+
+        if !isReadOperation(op) {
+                return nil, nodes[0].unavailable()
+        }
+        return nil, fmt.Errorf("%w on cluster %s: %v", ErrNoNodeAvailable, cl.Name, lastErr)
+}
+
+// Function to post a SOAP request to one node; reached is false when the node did not answer.
+// The body is returned with a fault as well, so it can be traced
+func postAXLRequest(ctx context.Context, cl *Cluster, node *clusterNode, soapRequest string) (body []byte, reached bool, err error) {
+        // with a CUCM session in the jar the cookie is sent instead of the credentials
+        session := cl.hasSession(node)
+        resp, err := doAXLRequest(ctx, cl, node, soapRequest, !session)
+        if err != nil {
+                return nil, false, err
+        }
+        if resp.StatusCode == http.StatusUnauthorized && session {
+                // the session expired on CUCM; drop it and log in again
+                resp.Body.Close()
+                cl.clearSession(node)
+                if resp, err = doAXLRequest(ctx, cl, node, soapRequest, true); err != nil {
+                        return nil, false, err
+                }
+        }
+        defer resp.Body.Close()
+
+        body, err = ioutil.ReadAll(resp.Body)
+        if err != nil {
+                return nil, true, fmt.Errorf("failed to read response body: %v", err)
+        }
+
+        // SOAP faults arrive as HTTP 500, bad credentials and throttling as bare 401/503
+        if err := parseAXLFault(resp.StatusCode, body); err != nil {
+                return body, true, err
+        }
+
+        return body, true, nil
+}
+
+// Function to send one HTTP request to a node, with Basic auth when login is set
+func doAXLRequest(ctx context.Context, cl *Cluster, node *clusterNode, soapRequest string, login bool) (*http.Response, error) {
+        // a request that never got a connection failed to dial or in the TLS handshake
+        var connected atomic.Bool
+        ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+                GotConn: func(httptrace.GotConnInfo) { connected.Store(true) },
+        })
+
+        req, err := http.NewRequestWithContext(ctx, "POST", node.url.String(), strings.NewReader(soapRequest))
         if err != nil {
                 return nil, fmt.Errorf("failed to create HTTP request: %v", err)
         }
         req.Header.Set("Content-Type", "text/xml")
-        req.Header.Set("SOAPAction", "CUCM:DB ver=14.0")
+        axl := &cl.AXL
+        if probe, ok := ctx.Value(probeAXLKey{}).(*AXLConfig); ok {
+                axl = probe
+        }
+        req.Header.Set("SOAPAction", axl.SOAPAction())
 
-        username := ""
-        password := ""
-        auth := username + ":" + password
-        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+        if login {
+                auth := cl.AXL.Username + ":" + cl.AXL.Password
+                req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+        }
 
-        resp, err := httpClient.Do(req)
+        resp, err := node.client.Do(req)
         if err != nil {
+                var netErr net.Error
+                switch {
+                case !connected.Load():
+                        return nil, fmt.Errorf("%w: %v", ErrNodeUnreachable, explainTLSError(err))
+                case errors.As(err, &netErr) && netErr.Timeout():
+                        return nil, fmt.Errorf("%w: %s %s: %v", ErrAXLTimeout, node.role(), node.Host, err)
+                }
                 return nil, fmt.Errorf("failed to send HTTP request: %v", err)
         }
-        defer resp.Body.Close()
+        return resp, nil
+}
+
+// Function to run a read-only Informix query through AXL executeSQLQuery
+func executeSQLQuery(ctx context.Context, sql string) ([]SQLRow, error) {
+        var resp ExecuteSQLQueryResp
+        if err := callAXL(ctx, &ExecuteSQLQueryReq{SQL: sql}, &resp); err != nil {
+                return nil, err
+        }
+        return resp.Body.ExecuteSQLQueryResponse.Return.Rows, nil
+}
+
+// Function to quote a value as an Informix string literal
+func sqlQuote(value string) string {
+        return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Function to add a case-insensitive LIKE condition on a column for every pattern that was given
+func sqlLikeConditions(conds []string, columns map[string]string) []string {
+        names := make([]string, 0, len(columns))
+        for column := range columns {
+                names = append(names, column)
+        }
+        // sorted so the same search always sends the same statement
+        sort.Strings(names)
+        for _, column := range names {
+                if pattern := columns[column]; pattern != "" {
+                        conds = append(conds, fmt.Sprintf("LOWER(%s) LIKE LOWER(%s)", column, sqlQuote(pattern)))
+                }
+        }
+        return conds
+}
+
+// Function to read every key with its own AXL request, in parallel and in key order; the request slots
+// of the cluster bound how many run at once, and keys that no longer exist are left out
+func lookupEach[T any](ctx context.Context, keys []string, lookup func(ctx context.Context, key string) (T, error)) ([]T, error) {
+        found := make([]T, len(keys))
+        errs := make([]error, len(keys))
+
+        var wg sync.WaitGroup
+        for i, key := range keys {
+                wg.Add(1)
+                go func(i int, key string) {
+                        defer wg.Done()
+                        found[i], errs[i] = lookup(ctx, key)
+                }(i, key)
+        }
+        wg.Wait()
+
+        all := make([]T, 0, len(keys))
+        for i := range keys {
+                switch {
+                case errors.Is(errs[i], ErrAXLNotFound):
+                case errs[i] != nil:
+                        return nil, errs[i]
+                default:
+                        all = append(all, found[i])
+                }
+        }
+        return all, nil
+}
+
+// Function to read a <row> whose child elements are the selected columns
+func (row *SQLRow) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+        *row = make(SQLRow)
+        for {
+                tok, err := d.Token()
+                if err != nil {
+                        return err
+                }
+                switch t := tok.(type) {
+                case xml.StartElement:
+                        var value string
+                        if err := d.DecodeElement(&value, &t); err != nil {
+                                return err
+                        }
+                        (*row)[t.Name.Local] = value
+                case xml.EndElement:
+                        return nil
+                }
+        }
+}
 
-        body, err := ioutil.ReadAll(resp.Body)
+// Function to read skip/first (or an opaque cursor) from the query string
+func parsePage(r *http.Request) (skip, first int, err error) {
+        q := r.URL.Query()
+        first = defaultPageSize
+
+        if v := q.Get("first"); v != "" {
+                if first, err = strconv.Atoi(v); err != nil || first < 1 || first > maxPageSize {
+                        return 0, 0, fmt.Errorf("first must be between 1 and %d", maxPageSize)
+                }
+        }
+
+        if v := q.Get("cursor"); v != "" {
+                raw, err := base64.RawURLEncoding.DecodeString(v)
+                if err != nil {
+                        return 0, 0, fmt.Errorf("invalid cursor")
+                }
+                if _, err := fmt.Sscanf(string(raw), "skip=%d", &skip); err != nil || skip < 0 {
+                        return 0, 0, fmt.Errorf("invalid cursor")
+                }
+                return skip, first, nil
+        }
+
+        if v := q.Get("skip"); v != "" {
+                if skip, err = strconv.Atoi(v); err != nil || skip < 0 {
+                        return 0, 0, fmt.Errorf("skip must be a non-negative number")
+                }
+        }
+        return skip, first, nil
+}
+
+// Function to build the cursor for the page after skip..skip+first, or "" on the last page
+func nextCursor(skip, first, returned int) string {
+        if returned < first {
+                return ""
+        }
+        return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("skip=%d", skip+first)))
+}
+
+// Function to make a search without criteria match everything, since AXL list operations need at least
+// one criterion; field is the criterion that is set to the % wildcard
+func matchAllIfEmpty[C comparable](criteria *C, field *string) {
+        var none C
+        if *criteria == none {
+                *field = "%"
+        }
+}
+
+// Function to turn client wildcards (*) into AXL LIKE wildcards (%)
+func axlWildcard(value string) string {
+        return strings.ReplaceAll(value, "*", "%")
+}
+
+// Function to decode a PATCH body into model and return the JSON keys the caller supplied
+func decodePatch(r *http.Request, model interface{}) ([]string, error) {
+        body, err := ioutil.ReadAll(r.Body)
         if err != nil {
-                return nil, fmt.Errorf("failed to read response body: %v", err)
+                return nil, fmt.Errorf("failed to read request body: %v", err)
+        }
+
+        // the body itself is decoded into model so error offsets point into what the client sent
+        var raw map[string]json.RawMessage
+        if err := json.Unmarshal(body, &raw); err != nil {
+                return nil, newBodyDecodeError(body, err)
+        }
+        if err := json.Unmarshal(body, model); err != nil {
+                return nil, newBodyDecodeError(body, err)
         }
 
-        return body, nil
+        keys := make([]string, 0, len(raw))
+        for key := range raw {
+                keys = append(keys, key)
+        }
+        return keys, nil
 }
 
-// Function to send JSON responses
+// Function to send JSON responses; error statuses get the error envelope
 func jsonResponse(w http.ResponseWriter, statusCode int, message string, data interface{}) {
+        if statusCode >= http.StatusBadRequest {
+                errorResponse(w, statusCode, message, data)
+                return
+        }
+        response := JsonResponse{
+                Status:  "success",
+                Message: message,
+                Data:    data,
+        }
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(statusCode)
+        json.NewEncoder(w).Encode(response)
+        // only the message is logged; the data can hold user records or SQL rows
+        logResponse("success", message)
+}
+
+// Function to send a page of results with the cursor for the next page
+func jsonPageResponse(w http.ResponseWriter, statusCode int, message string, data interface{}, next string) {
         response := JsonResponse{
                 Status:  "success",
                 Message: message,
                 Data:    data,
+                Next:    next,
         }
         w.Header().Set("Content-Type", "application/json")
         w.WriteHeader(statusCode)
         json.NewEncoder(w).Encode(response)
-        logResponse("success", message, data)
+        logResponse("success", message)
 }
 
-// Function to log responses
-func logResponse(status, message string, data interface{}) {
+// Function to send JSON error responses, with the code that goes with the status
+func errorResponse(w http.ResponseWriter, statusCode int, message string, details interface{}) {
+        writeErrorResponse(w, statusCode, ErrorResponse{Message: message, Details: details})
+}
+
+// Function to log the outcome of a response, without its data
+func logResponse(status, message string) {
         logData := JsonResponse{
                 Status:  status,
                 Message: message,
-                Data:    data,
         }
         log.Println(logData)
 }
@@ -0,0 +1,325 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/xml"
+        "fmt"
+        "net/http"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// GetPhoneAXLReq structure for SOAP request
+type GetPhoneAXLReq struct {
+        XMLName      xml.Name     `xml:"axl:getPhone"`
+        Name         string       `xml:"name"`
+        ReturnedTags ReturnedTags `xml:"returnedTags,omitempty"`
+}
+
+// GetPhoneResp structure for SOAP response
+type GetPhoneResp struct {
+        Body struct {
+                GetPhoneResponse struct {
+                        Return struct {
+                                Phone AddPhoneReq `xml:"phone"`
+                        } `xml:"return"`
+                } `xml:"getPhoneResponse"`
+        } `xml:"Body"`
+}
+
+// UpdatePhoneAXLReq structure for SOAP request; Fields holds only the elements being changed
+type UpdatePhoneAXLReq struct {
+        XMLName xml.Name `xml:"axl:updatePhone"`
+        Name    string   `xml:"name"`
+        NewName string   `xml:"newName,omitempty"`
+        Fields  []rawElement
+}
+
+// RemovePhoneAXLReq structure for SOAP request
+type RemovePhoneAXLReq struct {
+        XMLName xml.Name `xml:"axl:removePhone"`
+        Name    string   `xml:"name"`
+}
+
+// ListPhoneAXLReq structure for SOAP request
+type ListPhoneAXLReq struct {
+        XMLName        xml.Name            `xml:"axl:listPhone"`
+        SearchCriteria PhoneSearchCriteria `xml:"searchCriteria"`
+        ReturnedTags   ReturnedTags        `xml:"returnedTags"`
+        Skip           int                 `xml:"skip,omitempty"`
+        First          int                 `xml:"first,omitempty"`
+}
+
+// PhoneSearchCriteria are the AXL listPhone filters; % is the wildcard
+type PhoneSearchCriteria struct {
+        Name           string `xml:"name,omitempty"`
+        Description    string `xml:"description,omitempty"`
+        Protocol       string `xml:"protocol,omitempty"`
+        DevicePoolName string `xml:"devicePoolName,omitempty"`
+}
+
+// ListPhoneResp structure for SOAP response
+type ListPhoneResp struct {
+        Body struct {
+                ListPhoneResponse struct {
+                        Return struct {
+                                Phone []AddPhoneReq `xml:"phone"`
+                        } `xml:"return"`
+                } `xml:"listPhoneResponse"`
+        } `xml:"Body"`
+}
+
+// Fields returned by GET /phones when the caller does not choose any
+var defaultPhoneListFields = []string{"name", "description", "product", "protocol", "devicePoolName"}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for the phone collection: GET lists, POST adds
+func handlePhonesRequest(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case http.MethodGet:
+                handleListPhonesRequest(w, r)
+        case http.MethodPost:
+                handleAddPhoneRequest(w, r)
+        default:
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+        }
+}
+
+// Handler function for a single phone: GET reads, PATCH updates, DELETE removes
+func handlePhoneRequest(w http.ResponseWriter, r *http.Request) {
+        name := strings.TrimPrefix(r.URL.Path, "/phones/")
+        if name == "" || strings.Contains(name, "/") {
+                errorResponse(w, http.StatusNotFound, "Unknown phone path", nil)
+                return
+        }
+
+        switch r.Method {
+        case http.MethodGet:
+                handleGetPhoneRequest(w, r, name)
+        case http.MethodPatch:
+                handleUpdatePhoneRequest(w, r, name)
+        case http.MethodDelete:
+                handleRemovePhoneRequest(w, r, name)
+        default:
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+        }
+}
+
+// Handler function for reading a phone
+func handleGetPhoneRequest(w http.ResponseWriter, r *http.Request, name string) {
+        var fields []string
+        if v := r.URL.Query().Get("fields"); v != "" {
+                fields = strings.Split(v, ",")
+        }
+        tags, err := returnedTagsFor(AddPhoneReq{}, fields)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        var resp GetPhoneResp
+        if err := callAXL(r.Context(), &GetPhoneAXLReq{Name: name, ReturnedTags: tags}, &resp); err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        phone, err := selectFields(resp.Body.GetPhoneResponse.Return.Phone, fields)
+        if err != nil {
+                errorResponse(w, http.StatusInternalServerError, "Failed to encode phone: "+err.Error(), nil)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Phone retrieved successfully", phone)
+}
+
+// Handler function for updating only the supplied fields of a phone
+func handleUpdatePhoneRequest(w http.ResponseWriter, r *http.Request, name string) {
+        var req AddPhoneReq
+        supplied, err := decodePatch(r, &req)
+        if err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+        if err := validateRequest(&req, true); err != nil {
+                validationErrorResponse(w, err)
+                return
+        }
+        req.normalize()
+
+        var keys []string
+        for _, key := range supplied {
+                if key != "name" {
+                        keys = append(keys, key)
+                }
+        }
+        // partial updates bypass the check in marshalAXLRequest, so run it on the model
+        dropped, err := checkAXLFields(r.Context(), &req)
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+        keys = removeStrings(keys, dropped)
+
+        tags, err := returnedTagsFor(AddPhoneReq{}, keys)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+        fields, err := partialElements(&req, tagSet(tags))
+        if err != nil {
+                errorResponse(w, http.StatusInternalServerError, "Failed to build request", nil)
+                logResponse("error", err.Error())
+                return
+        }
+
+        update := &UpdatePhoneAXLReq{Name: name, Fields: fields}
+        if req.Name != "" && req.Name != name {
+                update.NewName = req.Name
+        }
+        if len(fields) == 0 && update.NewName == "" {
+                errorResponse(w, http.StatusBadRequest, "No fields to update", nil)
+                return
+        }
+
+        var resp StandardResp
+        if err := callAXL(r.Context(), update, &resp); err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Phone updated successfully", resp.Body.Response.Return)
+}
+
+// Handler function for removing a phone
+func handleRemovePhoneRequest(w http.ResponseWriter, r *http.Request, name string) {
+        var resp StandardResp
+        if err := callAXL(r.Context(), &RemovePhoneAXLReq{Name: name}, &resp); err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Phone removed successfully", resp.Body.Response.Return)
+}
+
+// Handler function for searching phones
+func handleListPhonesRequest(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        skip, first, err := parsePage(r)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        fields := append([]string(nil), defaultPhoneListFields...)
+        if v := q.Get("fields"); v != "" {
+                fields = strings.Split(v, ",")
+        }
+        tags, err := returnedTagsFor(AddPhoneReq{}, fields)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        criteria := PhoneSearchCriteria{
+                Name:           axlWildcard(q.Get("name")),
+                Description:    axlWildcard(q.Get("description")),
+                Protocol:       q.Get("protocol"),
+                DevicePoolName: axlWildcard(q.Get("devicePoolName")),
+        }
+        model := axlWildcard(q.Get("model"))
+        matchAllIfEmpty(&criteria, &criteria.Name)
+
+        phones, returned, err := listOnClusters(r.Context(), func(ctx context.Context) (interface{}, int, error) {
+                var phones []AddPhoneReq
+                var returned int
+                var err error
+                if model != "" {
+                        phones, returned, err = listPhonesByModel(ctx, criteria, model, tags, skip, first)
+                } else {
+                        var resp ListPhoneResp
+                        err = callAXL(ctx, &ListPhoneAXLReq{
+                                SearchCriteria: criteria,
+                                ReturnedTags:   tags,
+                                Skip:           skip,
+                                First:          first,
+                        }, &resp)
+                        phones = resp.Body.ListPhoneResponse.Return.Phone
+                        returned = len(phones)
+                }
+                if err != nil {
+                        return nil, 0, err
+                }
+
+                if phones == nil {
+                        phones = []AddPhoneReq{}
+                }
+                selected, err := selectFields(phones, fields)
+                return selected, returned, err
+        })
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonPageResponse(w, http.StatusOK, "Phones retrieved successfully", phones, nextCursor(skip, first, returned))
+}
+
+/****
+*
+* Helper functions
+*
+*/
+
+// Function to list phones by model (the AXL product name), which is not a listPhone criterion: the page
+// of device names is matched with executeSQLQuery, so skip and first count matching phones, then each
+// phone is read with getPhone. Returns the phones and the number of names the page matched
+func listPhonesByModel(ctx context.Context, criteria PhoneSearchCriteria, model string, tags ReturnedTags, skip, first int) ([]AddPhoneReq, int, error) {
+        // tkclass 1 is Phone, the class listPhone returns
+        conds := sqlLikeConditions([]string{"d.tkclass = 1"}, map[string]string{
+                "tp.name":       model,
+                "d.name":        criteria.Name,
+                "d.description": criteria.Description,
+                "p.name":        criteria.Protocol,
+                "dp.name":       criteria.DevicePoolName,
+        })
+        sql := fmt.Sprintf("SELECT SKIP %d FIRST %d d.name FROM device d "+
+                "JOIN typeproduct tp ON tp.enum = d.tkproduct "+
+                "JOIN typedeviceprotocol p ON p.enum = d.tkdeviceprotocol "+
+                "LEFT JOIN devicepool dp ON dp.pkid = d.fkdevicepool "+
+                "WHERE %s ORDER BY d.name", skip, first, strings.Join(conds, " AND "))
+        rows, err := executeSQLQuery(ctx, sql)
+        if err != nil {
+                return nil, 0, err
+        }
+
+        names := make([]string, len(rows))
+        for i, row := range rows {
+                names[i] = row["name"]
+        }
+        phones, err := lookupEach(ctx, names, func(ctx context.Context, name string) (AddPhoneReq, error) {
+                var resp GetPhoneResp
+                err := callAXL(ctx, &GetPhoneAXLReq{Name: name, ReturnedTags: tags}, &resp)
+                return resp.Body.GetPhoneResponse.Return.Phone, err
+        })
+        if err != nil {
+                return nil, 0, err
+        }
+        return phones, len(rows), nil
+}
@@ -0,0 +1,284 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/csv"
+        "encoding/json"
+        "flag"
+        "fmt"
+        "io"
+        "net/http"
+        "os"
+        "sort"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// LocationReport lists the lines, phones and users that belong to one location of a cluster
+type LocationReport struct {
+        Cluster          string            `json:"cluster"`
+        Location         string            `json:"location"`
+        Phones           []ReportPhone     `json:"phones"`
+        Users            []User            `json:"users"`
+        DirectoryNumbers []DirectoryNumber `json:"directoryNumbers"`
+}
+
+// ReportPhone is the part of a phone shown in the location report
+type ReportPhone struct {
+        Name           string `json:"name"`
+        Description    string `json:"description"`
+        DevicePoolName string `json:"devicePoolName"`
+}
+
+// reportName is a first/last name pair taken from a line description
+type reportName struct {
+        FirstName string
+        LastName  string
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to build the location reports of the request's cluster, or of every cluster for cluster "all"
+func locationReports(ctx context.Context, only string) ([]LocationReport, error) {
+        if !isFanOut(ctx) {
+                return buildLocationReports(ctx, only)
+        }
+
+        var reports []LocationReport
+        for _, cl := range allClusters() {
+                clusterReports, err := buildLocationReports(withCluster(ctx, cl), only)
+                if err != nil {
+                        return nil, fmt.Errorf("cluster %s: %w", cl.Name, err)
+                }
+                reports = append(reports, clusterReports...)
+        }
+        if reports == nil {
+                reports = []LocationReport{}
+        }
+        return reports, nil
+}
+
+// Function to build the location report: lines are grouped by the location in their description,
+// then phones whose description contains the location and users named in the lines are looked up
+func buildLocationReports(ctx context.Context, only string) ([]LocationReport, error) {
+        cluster := clusterFrom(ctx).Name
+        re := config.Reports.locationRegexp
+        locIdx := re.SubexpIndex("location")
+        firstIdx, lastIdx := re.SubexpIndex("firstName"), re.SubexpIndex("lastName")
+
+        lines, err := listAll(ctx, func(skip, first int) interface{} {
+                return &ListLineAXLReq{
+                        SearchCriteria: LineSearchCriteria{Description: "%"},
+                        ReturnedTags:   ReturnedTags{"pattern", "routePartitionName", "description"},
+                        Skip:           skip,
+                        First:          first,
+                }
+        }, func(resp *ListLineResp) []DirectoryNumber { return resp.Body.ListLineResponse.Return.Line })
+        if err != nil {
+                return nil, err
+        }
+
+        byLocation := make(map[string]*LocationReport)
+        names := make(map[string][]reportName)
+        for _, dn := range lines {
+                m := re.FindStringSubmatch(dn.Description)
+                if m == nil {
+                        continue
+                }
+                location := strings.TrimSpace(m[locIdx])
+                if location == "" || (only != "" && !strings.EqualFold(only, location)) {
+                        continue
+                }
+
+                report, ok := byLocation[location]
+                if !ok {
+                        report = &LocationReport{Cluster: cluster, Location: location, Phones: []ReportPhone{}, Users: []User{}}
+                        byLocation[location] = report
+                }
+                report.DirectoryNumbers = append(report.DirectoryNumbers, dn)
+
+                if firstIdx >= 0 && lastIdx >= 0 && m[firstIdx] != "" && m[lastIdx] != "" {
+                        names[location] = append(names[location], reportName{m[firstIdx], m[lastIdx]})
+                }
+        }
+
+        reports := make([]LocationReport, 0, len(byLocation))
+        for location, report := range byLocation {
+                phones, err := listAll(ctx, func(skip, first int) interface{} {
+                        return &ListPhoneAXLReq{
+                                SearchCriteria: PhoneSearchCriteria{Description: "%" + location + "%"},
+                                ReturnedTags:   ReturnedTags{"name", "description", "devicePoolName"},
+                                Skip:           skip,
+                                First:          first,
+                        }
+                }, func(resp *ListPhoneResp) []AddPhoneReq { return resp.Body.ListPhoneResponse.Return.Phone })
+                if err != nil {
+                        return nil, err
+                }
+                for _, p := range phones {
+                        report.Phones = append(report.Phones, ReportPhone{p.Name, p.Description, p.DevicePoolName})
+                }
+
+                seen := make(map[string]bool)
+                for _, name := range names[location] {
+                        users, err := listAll(ctx, func(skip, first int) interface{} {
+                                return &ListUserAXLReq{
+                                        SearchCriteria: UserSearchCriteria{
+                                                FirstName: "%" + name.FirstName + "%",
+                                                LastName:  "%" + name.LastName + "%",
+                                        },
+                                        ReturnedTags: ReturnedTags{"userid", "firstName", "lastName"},
+                                        Skip:         skip,
+                                        First:        first,
+                                }
+                        }, func(resp *ListUserResp) []User { return resp.Body.ListUserResponse.Return.User })
+                        if err != nil {
+                                return nil, err
+                        }
+                        for _, u := range users {
+                                if !seen[u.Userid] {
+                                        seen[u.Userid] = true
+                                        report.Users = append(report.Users, u)
+                                }
+                        }
+                }
+
+                reports = append(reports, *report)
+        }
+
+        sort.Slice(reports, func(i, j int) bool { return reports[i].Location < reports[j].Location })
+        return reports, nil
+}
+
+// Function to page through a list operation until every matching row has been read; request builds
+// the AXL request for one page and rows reads the page out of the response
+func listAll[R, T any](ctx context.Context, request func(skip, first int) interface{}, rows func(resp *R) []T) ([]T, error) {
+        var all []T
+        for skip := 0; ; skip += maxPageSize {
+                var resp R
+                if err := callAXL(ctx, request(skip, maxPageSize), &resp); err != nil {
+                        return nil, err
+                }
+                page := rows(&resp)
+                all = append(all, page...)
+                if len(page) < maxPageSize {
+                        return all, nil
+                }
+        }
+}
+
+// Function to write reports as CSV, one row per phone, user or line
+func writeLocationCSV(out io.Writer, reports []LocationReport) error {
+        w := csv.NewWriter(out)
+        w.Write([]string{"cluster", "location", "type", "id", "name", "description", "detail"})
+        for _, r := range reports {
+                for _, p := range r.Phones {
+                        w.Write([]string{r.Cluster, r.Location, "phone", p.Name, "", p.Description, p.DevicePoolName})
+                }
+                for _, u := range r.Users {
+                        w.Write([]string{r.Cluster, r.Location, "user", u.Userid, strings.TrimSpace(u.FirstName + " " + u.LastName), "", ""})
+                }
+                for _, dn := range r.DirectoryNumbers {
+                        w.Write([]string{r.Cluster, r.Location, "line", dn.Pattern, "", dn.Description, dn.RoutePartitionName})
+                }
+        }
+        w.Flush()
+        return w.Error()
+}
+
+// Function to run "cm-gator report location [-format json|csv] [-location name] [-cluster name|all]"
+func runReportCommand(args []string) error {
+        if len(args) == 0 || args[0] != "location" {
+                return fmt.Errorf("usage: cm-gator report location [-format json|csv] [-location name] [-cluster name|all]")
+        }
+
+        fs := flag.NewFlagSet("report location", flag.ContinueOnError)
+        format := fs.String("format", "json", "output format: json or csv")
+        location := fs.String("location", "", "only report this location")
+        cluster := fs.String("cluster", "", "cluster to report on, or \"all\" (default the default cluster)")
+        if err := fs.Parse(args[1:]); err != nil {
+                return err
+        }
+        if *format != "json" && *format != "csv" {
+                return fmt.Errorf("unknown format %q (use json or csv)", *format)
+        }
+
+        ctx := context.Background()
+        switch {
+        case *cluster == "":
+        case *cluster == clusterAll:
+                ctx = context.WithValue(ctx, fanOutKey{}, true)
+        case config.clusters[*cluster] != nil:
+                ctx = withCluster(ctx, config.clusters[*cluster])
+        default:
+                return fmt.Errorf("unknown cluster %q", *cluster)
+        }
+
+        reports, err := locationReports(ctx, *location)
+        if err != nil {
+                return err
+        }
+
+        if *format == "csv" {
+                return writeLocationCSV(os.Stdout, reports)
+        }
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        return enc.Encode(reports)
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for the location report, GET /reports/location?format=json|csv&location=
+func handleLocationReportRequest(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+                return
+        }
+
+        q := r.URL.Query()
+        format := q.Get("format")
+        if format == "" {
+                format = "json"
+        }
+        if format != "json" && format != "csv" {
+                errorResponse(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q (use json or csv)", format), nil)
+                return
+        }
+
+        reports, err := locationReports(r.Context(), q.Get("location"))
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        if format == "csv" {
+                w.Header().Set("Content-Type", "text/csv")
+                w.Header().Set("Content-Disposition", `attachment; filename="location-report.csv"`)
+                if err := writeLocationCSV(w, reports); err != nil {
+                        logResponse("error", err.Error())
+                }
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "Location report generated successfully", reports)
+}
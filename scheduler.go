@@ -0,0 +1,216 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "math/rand"
+        "net/http"
+        "strings"
+        "sync"
+        "time"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// axlLane is the priority of an AXL request; interactive requests are always served before bulk ones
+type axlLane int
+
+const (
+        laneInteractive axlLane = iota
+        laneBulk
+        laneCount
+)
+
+// axlScheduler hands out a cluster's AXL request slots, up to AXL.MaxConcurrent at once.
+// Waiters are served first-in first-out within a lane and the interactive lane always goes first.
+// Bulk requests never take the last free slot, so with two or more slots an interactive request never
+// waits for a bulk job; with a single slot bulk must use it, and interactive requests wait for the
+// running bulk request (but not for queued ones)
+type axlScheduler struct {
+        mu       sync.Mutex
+        size     int
+        inFlight int
+        bulk     int
+        waiting  [laneCount][]*schedWaiter
+}
+
+// schedWaiter is a request queued for a slot; ready is closed when the slot is handed over
+type schedWaiter struct {
+        ready chan struct{}
+}
+
+// Context key for the request's lane
+type laneKey struct{}
+
+// Header that lets a client put its requests in the bulk lane
+const priorityHeader = "X-AXL-Priority"
+
+// Routes that always run in the bulk lane
+var bulkRoutes = map[string]bool{
+        "/reports/location": true,
+        "/sql":              true,
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to create a scheduler with size slots
+func newAXLScheduler(size int) *axlScheduler {
+        if size < 1 {
+                size = 1
+        }
+        return &axlScheduler{size: size}
+}
+
+// Function to attach a lane to a context
+func withLane(ctx context.Context, lane axlLane) context.Context {
+        return context.WithValue(ctx, laneKey{}, lane)
+}
+
+// Function to find the lane of a request; requests without one are interactive
+func laneFrom(ctx context.Context) axlLane {
+        lane, _ := ctx.Value(laneKey{}).(axlLane)
+        return lane
+}
+
+// Function to name a lane for logs and GET /clusters
+func (l axlLane) String() string {
+        if l == laneBulk {
+                return "bulk"
+        }
+        return "interactive"
+}
+
+// Function to report whether a request in the lane may take a slot now
+func (s *axlScheduler) canRun(lane axlLane) bool {
+        if s.inFlight >= s.size {
+                return false
+        }
+        if lane == laneBulk {
+                // a single slot is shared, or bulk requests could never run
+                return s.size == 1 || s.inFlight < s.size-1
+        }
+        return true
+}
+
+// Function to wait for a slot in the given lane; the returned func gives it back
+func (s *axlScheduler) acquire(ctx context.Context, lane axlLane) (func(), error) {
+        s.mu.Lock()
+        if len(s.waiting[laneInteractive]) == 0 && (lane == laneInteractive || len(s.waiting[laneBulk]) == 0) && s.canRun(lane) {
+                s.take(lane)
+                s.mu.Unlock()
+                return func() { s.release(lane) }, nil
+        }
+        w := &schedWaiter{ready: make(chan struct{})}
+        s.waiting[lane] = append(s.waiting[lane], w)
+        s.mu.Unlock()
+
+        select {
+        case <-w.ready:
+                return func() { s.release(lane) }, nil
+        case <-ctx.Done():
+                s.mu.Lock()
+                defer s.mu.Unlock()
+                for i, queued := range s.waiting[lane] {
+                        if queued == w {
+                                s.waiting[lane] = append(s.waiting[lane][:i], s.waiting[lane][i+1:]...)
+                                return nil, ctx.Err()
+                        }
+                }
+                // the slot was handed over while the context ended; pass it on
+                s.give(lane)
+                return nil, ctx.Err()
+        }
+}
+
+// Function to count a slot as taken; the caller holds mu
+func (s *axlScheduler) take(lane axlLane) {
+        s.inFlight++
+        if lane == laneBulk {
+                s.bulk++
+        }
+}
+
+// Function to return a slot and hand it to the next waiter
+func (s *axlScheduler) release(lane axlLane) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        s.give(lane)
+}
+
+// Function to free a slot and wake waiters that can now run; the caller holds mu
+func (s *axlScheduler) give(lane axlLane) {
+        s.inFlight--
+        if lane == laneBulk {
+                s.bulk--
+        }
+        for next := laneInteractive; next < laneCount; next++ {
+                for len(s.waiting[next]) > 0 && s.canRun(next) {
+                        w := s.waiting[next][0]
+                        s.waiting[next] = s.waiting[next][1:]
+                        s.take(next)
+                        close(w.ready)
+                }
+                // bulk waiters only move once no interactive request is queued
+                if len(s.waiting[next]) > 0 {
+                        return
+                }
+        }
+}
+
+// Function to report the slots in use and the queued requests per lane
+func (s *axlScheduler) stats() (inFlight, bulk int, waiting map[string]int) {
+        s.mu.Lock()
+        defer s.mu.Unlock()
+        waiting = make(map[string]int, laneCount)
+        for lane := laneInteractive; lane < laneCount; lane++ {
+                waiting[lane.String()] = len(s.waiting[lane])
+        }
+        return s.inFlight, s.bulk, waiting
+}
+
+// Function to pick the wait before retry attempt n (1-based): the delay doubles from retry.baseDelay
+// up to retry.maxDelay, and a random part of up to half of it spreads out clients throttled together
+func (r RetryConfig) backoff(n int) time.Duration {
+        delay := r.BaseDelay
+        for i := 1; i < n && delay < r.MaxDelay; i++ {
+                delay *= 2
+        }
+        if delay > r.MaxDelay {
+                delay = r.MaxDelay
+        }
+        half := int64(delay / 2)
+        return time.Duration(half + rand.Int63n(half+1))
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Function to put bulk endpoints, and requests sent with X-AXL-Priority: bulk, in the bulk lane
+func priorityRouter(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                switch priority := strings.ToLower(r.Header.Get(priorityHeader)); {
+                case priority == "bulk" || bulkRoutes[r.URL.Path]:
+                        r = r.WithContext(withLane(r.Context(), laneBulk))
+                case priority != "" && priority != "interactive":
+                        errorResponse(w, http.StatusBadRequest, priorityHeader+" must be interactive or bulk", nil)
+                        return
+                }
+                next.ServeHTTP(w, r)
+        })
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+        "context"
+        "fmt"
+        "testing"
+        "time"
+)
+
+// Function to take a slot only if one is free now; a cancelled context makes acquire give up instead of waiting
+func tryAcquire(s *axlScheduler, lane axlLane) (func(), bool) {
+        ctx, cancel := context.WithCancel(context.Background())
+        cancel()
+        release, err := s.acquire(ctx, lane)
+        return release, err == nil
+}
+
+// Function to wait until the scheduler has queued the given number of requests per lane
+func waitQueued(t *testing.T, s *axlScheduler, interactive, bulk int) {
+        t.Helper()
+        for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+                if _, _, waiting := s.stats(); waiting["interactive"] == interactive && waiting["bulk"] == bulk {
+                        return
+                }
+        }
+        t.Fatalf("queue did not reach %d interactive and %d bulk requests", interactive, bulk)
+}
+
+func TestSchedulerBulkLeavesLastSlot(t *testing.T) {
+        tests := []struct {
+                size        int
+                bulk        int
+                interactive bool
+        }{
+                // a single slot is shared, so bulk takes it and interactive requests wait
+                {size: 1, bulk: 1, interactive: false},
+                {size: 2, bulk: 1, interactive: true},
+                {size: 3, bulk: 2, interactive: true},
+                {size: 8, bulk: 7, interactive: true},
+        }
+
+        for _, tt := range tests {
+                t.Run(fmt.Sprintf("%d slots", tt.size), func(t *testing.T) {
+                        s := newAXLScheduler(tt.size)
+                        bulk := 0
+                        for {
+                                if _, ok := tryAcquire(s, laneBulk); !ok {
+                                        break
+                                }
+                                bulk++
+                        }
+                        if bulk != tt.bulk {
+                                t.Errorf("bulk requests running = %d, want %d", bulk, tt.bulk)
+                        }
+                        if _, ok := tryAcquire(s, laneInteractive); ok != tt.interactive {
+                                t.Errorf("interactive request could run = %v, want %v", ok, tt.interactive)
+                        }
+                        if inFlight, _, waiting := s.stats(); waiting["interactive"]+waiting["bulk"] != 0 || inFlight > tt.size {
+                                t.Errorf("after giving up: %d in flight and %v queued", inFlight, waiting)
+                        }
+                })
+        }
+}
+
+func TestSchedulerSingleSlotOrder(t *testing.T) {
+        s := newAXLScheduler(1)
+        releaseFirst, ok := tryAcquire(s, laneBulk)
+        if !ok {
+                t.Fatal("bulk request could not take the free slot")
+        }
+
+        got := make(chan axlLane, 2)
+        run := func(lane axlLane) {
+                release, err := s.acquire(context.Background(), lane)
+                if err != nil {
+                        t.Error(err)
+                        return
+                }
+                got <- lane
+                release()
+        }
+        go run(laneBulk)
+        waitQueued(t, s, 0, 1)
+        go run(laneInteractive)
+        waitQueued(t, s, 1, 1)
+
+        // the interactive request waited for the running bulk request, but goes before the queued one
+        releaseFirst()
+        if first, second := <-got, <-got; first != laneInteractive || second != laneBulk {
+                t.Errorf("order = %s, %s; want interactive, bulk", first, second)
+        }
+        if inFlight, bulk, _ := s.stats(); inFlight != 0 || bulk != 0 {
+                t.Errorf("%d in flight (%d bulk) after every request released", inFlight, bulk)
+        }
+}
+
+func TestRetryBackoff(t *testing.T) {
+        tests := []struct {
+                name  string
+                retry RetryConfig
+                n     int
+                full  time.Duration
+        }{
+                {name: "first retry", retry: RetryConfig{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, n: 1, full: time.Second},
+                {name: "doubles", retry: RetryConfig{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, n: 3, full: 4 * time.Second},
+                {name: "capped", retry: RetryConfig{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, n: 6, full: 30 * time.Second},
+                {name: "many attempts", retry: RetryConfig{BaseDelay: time.Second, MaxDelay: 30 * time.Second}, n: 100, full: 30 * time.Second},
+                {name: "base above max", retry: RetryConfig{BaseDelay: time.Minute, MaxDelay: 10 * time.Second}, n: 1, full: 10 * time.Second},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        for i := 0; i < 200; i++ {
+                                if d := tt.retry.backoff(tt.n); d < tt.full/2 || d > tt.full {
+                                        t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.n, d, tt.full/2, tt.full)
+                                }
+                        }
+                })
+        }
+}
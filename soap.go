@@ -0,0 +1,244 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/json"
+        "encoding/xml"
+        "fmt"
+        "reflect"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// Envelope structure wrapped around every AXL SOAP request
+type Envelope struct {
+        XMLName      xml.Name `xml:"soapenv:Envelope"`
+        XmlnsSoapenv string   `xml:"xmlns:soapenv,attr"`
+        XmlnsAxl     string   `xml:"xmlns:axl,attr"`
+        Header       struct{} `xml:"soapenv:Header"`
+        Body         struct {
+                // Content is an operation struct whose XMLName names the AXL call (e.g. axl:addPhone)
+                Content interface{}
+        } `xml:"soapenv:Body"`
+}
+
+// StandardResp structure for SOAP responses that only return a uuid (update*, remove*)
+type StandardResp struct {
+        Body struct {
+                Response struct {
+                        Return string `xml:"return"`
+                } `xml:",any"`
+        } `xml:"Body"`
+}
+
+// rawElement is a pre-marshalled child element, used to send only some fields of a model
+type rawElement struct {
+        XMLName xml.Name
+        Attrs   []xml.Attr `xml:",any,attr"`
+        Inner   []byte     `xml:",innerxml"`
+}
+
+// ReturnedTags names the elements an AXL get/list call should return
+type ReturnedTags []string
+
+const soapenvNamespace = "http://schemas.xmlsoap.org/soap/envelope/"
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to wrap an AXL operation in a SOAP envelope for the cluster's AXL version
+func newEnvelope(cl *Cluster, content interface{}) *Envelope {
+        env := &Envelope{
+                XmlnsSoapenv: soapenvNamespace,
+                XmlnsAxl:     cl.AXL.Namespace(),
+        }
+        env.Body.Content = content
+        return env
+}
+
+// Function to marshal an AXL operation into a SOAP request string, after checking its fields against the AXL version
+func marshalAXLRequest(ctx context.Context, content interface{}) (string, error) {
+        if _, err := checkAXLFields(ctx, content); err != nil {
+                return "", err
+        }
+        out, err := xml.Marshal(newEnvelope(clusterFrom(ctx), content))
+        if err != nil {
+                return "", fmt.Errorf("failed to marshal SOAP request: %v", err)
+        }
+        return string(out), nil
+}
+
+// Function to marshal returned tags as empty elements, e.g. <returnedTags><userid/></returnedTags>
+func (t ReturnedTags) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+        if err := e.EncodeToken(start); err != nil {
+                return err
+        }
+        for _, tag := range t {
+                el := xml.StartElement{Name: xml.Name{Local: tag}}
+                if err := e.EncodeToken(el); err != nil {
+                        return err
+                }
+                if err := e.EncodeToken(el.End()); err != nil {
+                        return err
+                }
+        }
+        return e.EncodeToken(start.End())
+}
+
+// Function to translate JSON field names of a model into AXL returned tags
+func returnedTagsFor(model interface{}, fields []string) (ReturnedTags, error) {
+        if len(fields) == 0 {
+                return nil, nil
+        }
+
+        known := make(map[string]string)
+        t := reflect.TypeOf(model)
+        for i := 0; i < t.NumField(); i++ {
+                f := t.Field(i)
+                jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+                xmlName := strings.Split(f.Tag.Get("xml"), ",")[0]
+                if jsonName != "" && jsonName != "-" && xmlName != "" && xmlName != "-" {
+                        known[jsonName] = xmlName
+                }
+        }
+
+        tags := make(ReturnedTags, 0, len(fields))
+        for _, field := range fields {
+                tag, ok := known[field]
+                if !ok {
+                        return nil, fmt.Errorf("unknown field %q", field)
+                }
+                tags = append(tags, tag)
+        }
+        return tags, nil
+}
+
+// Function to keep only the requested JSON keys of a model, or of each model in a slice, for models
+// such as AddPhoneReq that are shared with add requests and so marshal every field
+func selectFields(v interface{}, fields []string) (interface{}, error) {
+        if len(fields) == 0 {
+                return v, nil
+        }
+        data, err := json.Marshal(v)
+        if err != nil {
+                return nil, err
+        }
+
+        if reflect.ValueOf(v).Kind() != reflect.Slice {
+                var row map[string]json.RawMessage
+                if err := json.Unmarshal(data, &row); err != nil {
+                        return nil, err
+                }
+                return keepKeys(row, fields), nil
+        }
+        var rows []map[string]json.RawMessage
+        if err := json.Unmarshal(data, &rows); err != nil {
+                return nil, err
+        }
+        for i, row := range rows {
+                rows[i] = keepKeys(row, fields)
+        }
+        return rows, nil
+}
+
+// Function to drop the keys of a decoded JSON object that are not in fields
+func keepKeys(row map[string]json.RawMessage, fields []string) map[string]json.RawMessage {
+        for key := range row {
+                if !containsString(fields, key) {
+                        delete(row, key)
+                }
+        }
+        return row
+}
+
+// Function to marshal a model and keep only the child elements named in keep, in model order.
+// A kept field that marshals to nothing (nil or empty) is sent as an empty element so AXL clears it.
+func partialElements(model interface{}, keep map[string]bool) ([]rawElement, error) {
+        out, err := xml.Marshal(model)
+        if err != nil {
+                return nil, fmt.Errorf("failed to marshal fields: %v", err)
+        }
+
+        var parsed struct {
+                Children []rawElement `xml:",any"`
+        }
+        if err := xml.Unmarshal(out, &parsed); err != nil {
+                return nil, fmt.Errorf("failed to split fields: %v", err)
+        }
+        marshalled := make(map[string]rawElement, len(parsed.Children))
+        for _, child := range parsed.Children {
+                marshalled[child.XMLName.Local] = child
+        }
+
+        var elements []rawElement
+        t := reflect.Indirect(reflect.ValueOf(model)).Type()
+        for i := 0; i < t.NumField(); i++ {
+                name := strings.Split(t.Field(i).Tag.Get("xml"), ",")[0]
+                if !keep[name] {
+                        continue
+                }
+                child, ok := marshalled[name]
+                if !ok {
+                        child = rawElement{XMLName: xml.Name{Local: name}}
+                }
+                elements = append(elements, child)
+        }
+        return elements, nil
+}
+
+// Function to turn returned tags into the keep set used by partialElements
+func tagSet(tags ReturnedTags) map[string]bool {
+        set := make(map[string]bool, len(tags))
+        for _, tag := range tags {
+                set[tag] = true
+        }
+        return set
+}
+
+// Function to read the AXL operation name from a request's XMLName tag, e.g. "listPhone"
+func axlOperation(content interface{}) string {
+        t := reflect.TypeOf(content)
+        for t.Kind() == reflect.Ptr {
+                t = t.Elem()
+        }
+        if t.Kind() != reflect.Struct {
+                return ""
+        }
+        f, ok := t.FieldByName("XMLName")
+        if !ok {
+                return ""
+        }
+        return strings.TrimPrefix(f.Tag.Get("xml"), "axl:")
+}
+
+// Function to send an AXL operation to the cluster in ctx and unmarshal the SOAP response into resp
+func callAXL(ctx context.Context, content interface{}, resp interface{}) error {
+        soapRequest, err := marshalAXLRequest(ctx, content)
+        if err != nil {
+                return err
+        }
+
+        response, err := sendAXLRequest(ctx, axlOperation(content), soapRequest)
+        if err != nil {
+                return err
+        }
+
+        if err := xml.Unmarshal(response, resp); err != nil {
+                return fmt.Errorf("failed to parse response: %v", err)
+        }
+        return nil
+}
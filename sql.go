@@ -0,0 +1,269 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "net/http"
+        "regexp"
+        "strconv"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// SQLQueryReq structure for the /sql request body
+type SQLQueryReq struct {
+        SQL       string `json:"sql"`
+        ChunkSize int    `json:"chunkSize,omitempty"`
+}
+
+var (
+        sqlSelectPattern    = regexp.MustCompile(`(?i)^\s*select\s`)
+        sqlForbiddenPattern = regexp.MustCompile(`(?i);|\binto\b`)
+        sqlSkipFirstPattern = regexp.MustCompile(`(?i)^\s*select\s+(skip|first|limit)\b`)
+        sqlFromPattern      = regexp.MustCompile(`(?i)\bfrom\s`)
+        sqlOrderByPattern   = regexp.MustCompile(`(?i)\border\s+by\s`)
+        // a chunked query reads one table, optionally aliased and filtered, so pkid is a unique order for it
+        sqlSingleTablePattern = regexp.MustCompile(`(?is)^from\s+[a-z_][a-z0-9_]*(?:\s+(?:as\s+)?[a-z_][a-z0-9_]*)?\s*(?:\bwhere\s.*)?$`)
+        sqlGroupedPattern     = regexp.MustCompile(`(?i)\b(?:group\s+by|having|union)\b`)
+        sqlPkidLastPattern    = regexp.MustCompile(`(?is)[\s,.]pkid(?:\s+(?:asc|desc))?\s*$`)
+        // "Query request too large. Total rows matched: 48000 rows. Suggestive Row Fetch: less than 12000 rows"
+        sqlSuggestedPattern = regexp.MustCompile(`(?i)suggestive row fetch: less than (\d+) rows`)
+)
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to blank out the contents of string literals, keeping their length so positions in the result
+// still line up with sql; comments are rejected rather than skipped, since a quote or keyword inside one
+// would otherwise be read by the checks while Informix ignores it
+func blankSQL(sql string) (string, error) {
+        bare := []byte(sql)
+        for i := 0; i < len(bare); i++ {
+                switch c := bare[i]; {
+                case c == '\'' || c == '"':
+                        end := i + 1
+                        for ; end < len(bare); end++ {
+                                if bare[end] != c {
+                                        continue
+                                }
+                                // a doubled quote is an escaped one
+                                if end+1 < len(bare) && bare[end+1] == c {
+                                        end++
+                                        continue
+                                }
+                                break
+                        }
+                        if end >= len(bare) {
+                                return "", errors.New("statement has an unterminated string literal")
+                        }
+                        for j := i + 1; j < end; j++ {
+                                bare[j] = 'x'
+                        }
+                        i = end
+                case c == '{', c == '-' && strings.HasPrefix(sql[i:], "--"), c == '/' && strings.HasPrefix(sql[i:], "/*"):
+                        return "", errors.New("comments are not allowed in the statement")
+                }
+        }
+        return string(bare), nil
+}
+
+// Function to find the first match of pattern at or after from that is not inside parentheses
+func topLevelMatch(bare string, pattern *regexp.Regexp, from int) int {
+        for _, loc := range pattern.FindAllStringIndex(bare[from:], -1) {
+                at := from + loc[0]
+                if strings.Count(bare[:at], "(") == strings.Count(bare[:at], ")") {
+                        return at
+                }
+        }
+        return -1
+}
+
+// Function to check that a statement is a single SELECT and return it without a trailing semicolon
+func readOnlySQL(sql string) (string, error) {
+        sql = strings.TrimSuffix(strings.TrimSpace(sql), ";")
+        bare, err := blankSQL(sql)
+        if err != nil {
+                return "", err
+        }
+        if !sqlSelectPattern.MatchString(bare) {
+                return "", errors.New("only SELECT statements are allowed")
+        }
+        if sqlForbiddenPattern.MatchString(bare) {
+                return "", errors.New("statement must be a single SELECT without INTO")
+        }
+        return sql, nil
+}
+
+// Function to order a SELECT by pkid so SKIP/FIRST chunks of it neither repeat nor miss rows; Informix gives
+// no stable order otherwise, so queries that pkid cannot order uniquely are refused
+func chunkableSQL(sql string) (string, error) {
+        bare, err := blankSQL(sql)
+        if err != nil {
+                return "", err
+        }
+        notChunkable := errors.New("only a SELECT from a single table without SKIP/FIRST, GROUP BY, HAVING or UNION, and ordered by pkid if at all, can be split into chunks")
+        if sqlSkipFirstPattern.MatchString(bare) || sqlGroupedPattern.MatchString(bare) {
+                return "", notChunkable
+        }
+        from := topLevelMatch(bare, sqlFromPattern, 0)
+        if from < 0 {
+                return "", notChunkable
+        }
+        order := topLevelMatch(bare, sqlOrderByPattern, from)
+        if order < 0 {
+                if !sqlSingleTablePattern.MatchString(bare[from:]) {
+                        return "", notChunkable
+                }
+                return sql + " ORDER BY pkid", nil
+        }
+        if !sqlSingleTablePattern.MatchString(strings.TrimSpace(bare[from:order])) || !sqlPkidLastPattern.MatchString(bare[order:]) {
+                return "", notChunkable
+        }
+        return sql, nil
+}
+
+// Function to add SKIP/FIRST to a SELECT so it returns one chunk of rows
+func chunkSQL(sql string, skip, first int) string {
+        loc := sqlSelectPattern.FindStringIndex(sql)
+        return fmt.Sprintf("%sSKIP %d FIRST %d %s", sql[:loc[1]], skip, first, sql[loc[1]:])
+}
+
+// Function to pick a chunk size from a "Query request too large" fault, smaller than CUCM suggests
+func suggestedChunkSize(err error) int {
+        var fault *AXLFault
+        if errors.As(err, &fault) {
+                if m := sqlSuggestedPattern.FindStringSubmatch(fault.AXLMessage + " " + fault.FaultString); m != nil {
+                        if n, _ := strconv.Atoi(m[1]); n > 1 {
+                                return n - 1
+                        }
+                }
+        }
+        return 0
+}
+
+// Function to run a SELECT and pass rows to emit one chunk at a time; the query runs whole first and
+// is only split into SKIP/FIRST chunks, ordered by pkid, when CUCM rejects it as too large
+func streamSQLQuery(ctx context.Context, sql string, chunkSize int, emit func([]SQLRow) error) error {
+        ordered, orderErr := chunkableSQL(sql)
+        if chunkSize == 0 {
+                rows, err := executeSQLQuery(ctx, sql)
+                if err == nil {
+                        return emit(rows)
+                }
+                if !errors.Is(err, ErrAXLQueryTooLarge) {
+                        return err
+                }
+                if orderErr != nil {
+                        return fmt.Errorf("%w; %v", err, orderErr)
+                }
+                if chunkSize = suggestedChunkSize(err); chunkSize == 0 {
+                        chunkSize = maxPageSize
+                }
+        } else if orderErr != nil {
+                return orderErr
+        }
+
+        for skip := 0; ; {
+                rows, err := executeSQLQuery(ctx, chunkSQL(ordered, skip, chunkSize))
+                if errors.Is(err, ErrAXLQueryTooLarge) && chunkSize > 1 {
+                        chunkSize /= 2
+                        continue
+                }
+                if err != nil {
+                        return err
+                }
+                if err := emit(rows); err != nil {
+                        return err
+                }
+                if len(rows) < chunkSize {
+                        return nil
+                }
+                skip += len(rows)
+        }
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for read-only SQL, streamed back as one JSON object per row (NDJSON)
+func handleSQLQueryRequest(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+                return
+        }
+
+        var req SQLQueryReq
+        if err := decodeJSON(r, &req); err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+        sql, err := readOnlySQL(req.SQL)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+        if req.ChunkSize < 0 {
+                errorResponse(w, http.StatusBadRequest, "chunkSize must not be negative", nil)
+                return
+        }
+        if req.ChunkSize > 0 {
+                if _, err := chunkableSQL(sql); err != nil {
+                        errorResponse(w, http.StatusBadRequest, "chunkSize cannot be used: "+err.Error(), nil)
+                        return
+                }
+        }
+
+        // the status line is only sent with the first chunk, so errors before it still get an error envelope
+        started := false
+        enc := json.NewEncoder(w)
+        flusher, _ := w.(http.Flusher)
+        err = streamSQLQuery(r.Context(), sql, req.ChunkSize, func(rows []SQLRow) error {
+                if !started {
+                        w.Header().Set("Content-Type", "application/x-ndjson")
+                        w.WriteHeader(http.StatusOK)
+                        started = true
+                }
+                for _, row := range rows {
+                        if err := enc.Encode(row); err != nil {
+                                return err
+                        }
+                }
+                if flusher != nil {
+                        flusher.Flush()
+                }
+                return nil
+        })
+        if err == nil {
+                return
+        }
+
+        if !started {
+                axlErrorResponse(w, err)
+                return
+        }
+        // rows have already been sent; end the stream with an error line the client can detect
+        _, resp := axlError(err)
+        resp.Status = "error"
+        resp.RequestID = w.Header().Get(requestIDHeader)
+        enc.Encode(resp)
+        logResponse("error", err.Error())
+}
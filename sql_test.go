@@ -0,0 +1,91 @@
+package main
+
+import (
+        "testing"
+)
+
+func TestReadOnlySQL(t *testing.T) {
+        tests := []struct {
+                name string
+                sql  string
+                want string
+                ok   bool
+        }{
+                {name: "select", sql: "SELECT name FROM device", want: "SELECT name FROM device", ok: true},
+                {name: "trailing semicolon", sql: "  select name from device;  ", want: "select name from device", ok: true},
+                {name: "into inside a literal", sql: "SELECT name FROM device WHERE description = 'move into lobby'", want: "SELECT name FROM device WHERE description = 'move into lobby'", ok: true},
+                {name: "semicolon inside a literal", sql: "SELECT name FROM device WHERE description = 'a;b'", want: "SELECT name FROM device WHERE description = 'a;b'", ok: true},
+                {name: "escaped quote", sql: "SELECT userid FROM enduser WHERE lastname = 'O''Brien'", want: "SELECT userid FROM enduser WHERE lastname = 'O''Brien'", ok: true},
+                {name: "select into", sql: "SELECT name INTO TEMP t FROM device"},
+                {name: "into after a literal", sql: "SELECT 'a' INTO TEMP t FROM device"},
+                {name: "multiple statements", sql: "SELECT name FROM device; DELETE FROM device"},
+                {name: "second select", sql: "SELECT name FROM device; SELECT name FROM enduser"},
+                {name: "literal hiding a second statement", sql: "SELECT 'x'; DELETE FROM device WHERE name = ';'"},
+                {name: "update", sql: "UPDATE device SET name = 'a'"},
+                {name: "leading literal", sql: "'SELECT' FROM device"},
+                {name: "double-quoted literal", sql: `SELECT name FROM device WHERE description = "a;b"`, want: `SELECT name FROM device WHERE description = "a;b"`, ok: true},
+                {name: "quote inside a line comment", sql: "SELECT name -- it's\n INTO TEMP t FROM device WHERE x = 'a'"},
+                {name: "line comment", sql: "SELECT name FROM device -- WHERE 1 = 0"},
+                {name: "block comment", sql: "SELECT name /* ' */ INTO TEMP t FROM device WHERE x = '*/'"},
+                {name: "brace comment", sql: "SELECT name { ' } INTO TEMP t FROM device WHERE x = '}'"},
+                {name: "comment marker inside a literal", sql: "SELECT name FROM device WHERE description = '-- {x} /* y */'", want: "SELECT name FROM device WHERE description = '-- {x} /* y */'", ok: true},
+                {name: "unterminated literal", sql: "SELECT name FROM device WHERE x = 'a"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        got, err := readOnlySQL(tt.sql)
+                        if !tt.ok {
+                                if err == nil {
+                                        t.Fatalf("readOnlySQL(%q) accepted the statement", tt.sql)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatalf("unexpected error: %v", err)
+                        }
+                        if got != tt.want {
+                                t.Errorf("got %q, want %q", got, tt.want)
+                        }
+                })
+        }
+}
+
+func TestChunkableSQL(t *testing.T) {
+        tests := []struct {
+                name string
+                sql  string
+                want string
+        }{
+                {name: "unordered", sql: "SELECT name FROM device", want: "SELECT name FROM device ORDER BY pkid"},
+                {name: "filtered", sql: "SELECT name FROM device WHERE tkclass = 1", want: "SELECT name FROM device WHERE tkclass = 1 ORDER BY pkid"},
+                {name: "alias ordered by pkid", sql: "SELECT n.dnorpattern FROM numplan n ORDER BY n.pkid", want: "SELECT n.dnorpattern FROM numplan n ORDER BY n.pkid"},
+                {name: "pkid as the last key", sql: "SELECT name FROM device ORDER BY name, pkid DESC", want: "SELECT name FROM device ORDER BY name, pkid DESC"},
+                {name: "subquery in the filter", sql: "SELECT name FROM device WHERE fkdevicepool IN (SELECT pkid FROM devicepool ORDER BY name)", want: "SELECT name FROM device WHERE fkdevicepool IN (SELECT pkid FROM devicepool ORDER BY name) ORDER BY pkid"},
+                {name: "ordered by another column", sql: "SELECT name FROM device ORDER BY name"},
+                {name: "ordered by a column ending in pkid", sql: "SELECT name FROM device ORDER BY fkpkid"},
+                {name: "join", sql: "SELECT d.name FROM device d JOIN devicepool p ON p.pkid = d.fkdevicepool"},
+                {name: "comma join", sql: "SELECT d.name FROM device d, devicepool p WHERE p.pkid = d.fkdevicepool"},
+                {name: "group by", sql: "SELECT tkclass, COUNT(*) FROM device GROUP BY tkclass"},
+                {name: "union", sql: "SELECT name FROM device UNION SELECT name FROM devicepool"},
+                {name: "skip first", sql: "SELECT FIRST 10 name FROM device"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        got, err := chunkableSQL(tt.sql)
+                        if tt.want == "" {
+                                if err == nil {
+                                        t.Fatalf("chunkableSQL(%q) = %q, want an error", tt.sql, got)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatalf("unexpected error: %v", err)
+                        }
+                        if got != tt.want {
+                                t.Errorf("got %q, want %q", got, tt.want)
+                        }
+                })
+        }
+}
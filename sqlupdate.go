@@ -0,0 +1,322 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/json"
+        "encoding/xml"
+        "errors"
+        "fmt"
+        "net/http"
+        "os"
+        "regexp"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// ExecuteSQLUpdateReq structure for SOAP request
+type ExecuteSQLUpdateReq struct {
+        XMLName xml.Name `xml:"axl:executeSQLUpdate"`
+        SQL     string   `xml:"sql"`
+}
+
+// ExecuteSQLUpdateResp structure for SOAP response
+type ExecuteSQLUpdateResp struct {
+        Body struct {
+                ExecuteSQLUpdateResponse struct {
+                        Return struct {
+                                RowsUpdated int `xml:"rowsUpdated"`
+                        } `xml:"return"`
+                } `xml:"executeSQLUpdateResponse"`
+        } `xml:"Body"`
+}
+
+// SQLUpdateReq structure for the /sql/update request body; without a token only a preview is returned
+type SQLUpdateReq struct {
+        SQL   string `json:"sql"`
+        Token string `json:"token,omitempty"`
+}
+
+// SQLUpdatePreview lists the rows a statement would change and the token that confirms it
+type SQLUpdatePreview struct {
+        Table     string    `json:"table"`
+        Select    string    `json:"select"`
+        RowCount  int       `json:"rowCount"`
+        Rows      []SQLRow  `json:"rows"`
+        Token     string    `json:"token"`
+        ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SQLUpdateResult is returned once the statement has run
+type SQLUpdateResult struct {
+        Table       string `json:"table"`
+        RowsUpdated int    `json:"rowsUpdated"`
+}
+
+// sqlAuditEntry is one line of the SQL update audit log
+type sqlAuditEntry struct {
+        Time        time.Time `json:"time"`
+        RemoteAddr  string    `json:"remoteAddr"`
+        Cluster     string    `json:"cluster"`
+        Action      string    `json:"action"`
+        SQL         string    `json:"sql"`
+        Table       string    `json:"table,omitempty"`
+        RowCount    int       `json:"rowCount"`
+        RowsUpdated int       `json:"rowsUpdated,omitempty"`
+        Error       string    `json:"error,omitempty"`
+}
+
+// sqlWrite is an UPDATE or DELETE split into its parts; both the preview SELECT and the statement that
+// is executed are built from them, so the two always cover the same rows
+type sqlWrite struct {
+        Table string
+        Set   string // empty for a DELETE
+        Where string
+}
+
+// Returned by the preview when a statement would change more than sqlUpdate.maxRows rows
+var ErrSQLTooManyRows = errors.New("too many rows")
+
+var (
+        sqlUpdatePattern = regexp.MustCompile(`(?is)^\s*update\s+([a-z_][a-z0-9_]*)\s+set\s`)
+        sqlDeletePattern = regexp.MustCompile(`(?is)^\s*delete\s+from\s+([a-z_][a-z0-9_]*)\s`)
+        sqlWherePattern  = regexp.MustCompile(`(?i)\bwhere\s`)
+
+        sqlTokenSecret []byte
+        sqlTokenOnce   sync.Once
+        sqlAuditMu     sync.Mutex
+)
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to accept a single UPDATE ... WHERE or DELETE FROM ... WHERE on an allowed table
+func parseSQLWrite(sql string) (*sqlWrite, error) {
+        sql = strings.TrimSuffix(strings.TrimSpace(sql), ";")
+        bare, err := blankSQL(sql)
+        if err != nil {
+                return nil, err
+        }
+        if strings.Contains(bare, ";") {
+                return nil, errors.New("statement must be a single UPDATE or DELETE")
+        }
+
+        isUpdate := true
+        m := sqlUpdatePattern.FindStringSubmatchIndex(bare)
+        if m == nil {
+                isUpdate = false
+                m = sqlDeletePattern.FindStringSubmatchIndex(bare)
+        }
+        where := -1
+        if m != nil {
+                where = topLevelMatch(bare, sqlWherePattern, m[1])
+        }
+        // an UPDATE needs its SET clause before the WHERE, a DELETE has nothing there
+        if where < 0 || isUpdate == (strings.TrimSpace(bare[m[1]:where]) == "") {
+                return nil, errors.New("only UPDATE ... SET ... WHERE ... and DELETE FROM ... WHERE ... are allowed")
+        }
+
+        w := &sqlWrite{
+                Table: strings.ToLower(sql[m[2]:m[3]]),
+                Where: strings.TrimSpace(sql[where+len("where"):]),
+        }
+        if isUpdate {
+                w.Set = strings.TrimSpace(sql[m[1]:where])
+        }
+        if !containsString(config.SQLUpdate.Tables, w.Table) {
+                return nil, fmt.Errorf("table %q is not in sqlUpdate.tables", w.Table)
+        }
+        return w, nil
+}
+
+// Function to build the statement that is executed
+func (s *sqlWrite) statementSQL() string {
+        if s.Set == "" {
+                return fmt.Sprintf("DELETE FROM %s WHERE %s", s.Table, s.Where)
+        }
+        return fmt.Sprintf("UPDATE %s SET %s WHERE %s", s.Table, s.Set, s.Where)
+}
+
+// Function to build the SELECT that returns the rows the statement would change, one past the limit
+func (s *sqlWrite) previewSQL() string {
+        return fmt.Sprintf("SELECT FIRST %d * FROM %s WHERE %s", config.SQLUpdate.MaxRows+1, s.Table, s.Where)
+}
+
+// Function to read the rows a statement would change and refuse it when there are too many
+func previewSQLWrite(ctx context.Context, s *sqlWrite) ([]SQLRow, error) {
+        rows, err := executeSQLQuery(ctx, s.previewSQL())
+        if err != nil {
+                return nil, err
+        }
+        if len(rows) > config.SQLUpdate.MaxRows {
+                return nil, fmt.Errorf("%w: statement would change more than %d rows (sqlUpdate.maxRows)", ErrSQLTooManyRows, config.SQLUpdate.MaxRows)
+        }
+        if rows == nil {
+                rows = []SQLRow{}
+        }
+        return rows, nil
+}
+
+// Function to sign a statement, its cluster, its preview rows and an expiry time; any change to the rows
+// between preview and execution, or sending the token to another cluster, produces a different token
+func sqlUpdateToken(cluster, sql string, rows []SQLRow, expires time.Time) string {
+        sqlTokenOnce.Do(func() {
+                if config.SQLUpdate.Secret != "" {
+                        sqlTokenSecret = []byte(config.SQLUpdate.Secret)
+                        return
+                }
+                sqlTokenSecret = make([]byte, 32)
+                rand.Read(sqlTokenSecret)
+        })
+
+        // rows are compared as a set because the preview SELECT has no ORDER BY
+        encoded := make([]string, len(rows))
+        for i, row := range rows {
+                b, _ := json.Marshal(row)
+                encoded[i] = string(b)
+        }
+        sort.Strings(encoded)
+
+        mac := hmac.New(sha256.New, sqlTokenSecret)
+        fmt.Fprintf(mac, "%d\n%s\n%s\n%s", expires.Unix(), cluster, sql, strings.Join(encoded, "\n"))
+        return strconv.FormatInt(expires.Unix(), 10) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Function to check a confirmation token against a fresh preview
+func checkSQLUpdateToken(token, cluster, sql string, rows []SQLRow) error {
+        exp, _, ok := strings.Cut(token, ".")
+        unix, err := strconv.ParseInt(exp, 10, 64)
+        if !ok || err != nil {
+                return errors.New("malformed token")
+        }
+        expires := time.Unix(unix, 0)
+        if time.Now().After(expires) {
+                return errors.New("token has expired; preview the statement again")
+        }
+        if !hmac.Equal([]byte(token), []byte(sqlUpdateToken(cluster, sql, rows, expires))) {
+                return errors.New("token does not match the statement or cluster, or the rows have changed; preview the statement again")
+        }
+        return nil
+}
+
+// Function to append an entry to the SQL update audit log
+func writeSQLAudit(entry sqlAuditEntry) {
+        sqlAuditMu.Lock()
+        defer sqlAuditMu.Unlock()
+
+        f, err := os.OpenFile(config.SQLUpdate.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+        if err != nil {
+                logResponse("error", "Failed to open SQL audit log: "+err.Error())
+                return
+        }
+        defer f.Close()
+
+        if err := json.NewEncoder(f).Encode(entry); err != nil {
+                logResponse("error", "Failed to write SQL audit log: "+err.Error())
+        }
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for guarded SQL writes: the first call previews, the second (with token) executes
+func handleSQLUpdateRequest(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodPost {
+                errorResponse(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+                return
+        }
+        if !config.SQLUpdate.Enabled {
+                errorResponse(w, http.StatusForbidden, "SQL updates are disabled (sqlUpdate.enabled)", nil)
+                return
+        }
+
+        var req SQLUpdateReq
+        if err := decodeJSON(r, &req); err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+        stmt, err := parseSQLWrite(req.SQL)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        cluster := clusterFrom(r.Context()).Name
+        audit := sqlAuditEntry{Time: time.Now().UTC(), RemoteAddr: r.RemoteAddr, Cluster: cluster, SQL: stmt.statementSQL(), Table: stmt.Table, Action: "preview"}
+        if req.Token != "" {
+                audit.Action = "execute"
+        }
+
+        rows, err := previewSQLWrite(r.Context(), stmt)
+        if err != nil {
+                audit.Error = err.Error()
+                writeSQLAudit(audit)
+                if errors.Is(err, ErrSQLTooManyRows) {
+                        errorResponse(w, http.StatusConflict, err.Error(), nil)
+                        return
+                }
+                axlErrorResponse(w, err)
+                return
+        }
+        audit.RowCount = len(rows)
+
+        if req.Token == "" {
+                expires := time.Now().Add(config.SQLUpdate.TokenTTL).Truncate(time.Second)
+                writeSQLAudit(audit)
+                jsonResponse(w, http.StatusOK, "Preview generated; send the token to apply the statement", SQLUpdatePreview{
+                        Table:     stmt.Table,
+                        Select:    stmt.previewSQL(),
+                        RowCount:  len(rows),
+                        Rows:      rows,
+                        Token:     sqlUpdateToken(cluster, stmt.statementSQL(), rows, expires),
+                        ExpiresAt: expires.UTC(),
+                })
+                return
+        }
+
+        if err := checkSQLUpdateToken(req.Token, cluster, stmt.statementSQL(), rows); err != nil {
+                audit.Error = err.Error()
+                writeSQLAudit(audit)
+                errorResponse(w, http.StatusConflict, err.Error(), nil)
+                return
+        }
+
+        var resp ExecuteSQLUpdateResp
+        if err := callAXL(r.Context(), &ExecuteSQLUpdateReq{SQL: stmt.statementSQL()}, &resp); err != nil {
+                audit.Error = err.Error()
+                writeSQLAudit(audit)
+                axlErrorResponse(w, err)
+                return
+        }
+        audit.RowsUpdated = resp.Body.ExecuteSQLUpdateResponse.Return.RowsUpdated
+        writeSQLAudit(audit)
+
+        jsonResponse(w, http.StatusOK, "Statement applied successfully", SQLUpdateResult{
+                Table:       stmt.Table,
+                RowsUpdated: audit.RowsUpdated,
+        })
+}
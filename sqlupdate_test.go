@@ -0,0 +1,222 @@
+package main
+
+import (
+        "strings"
+        "testing"
+        "time"
+)
+
+func TestParseSQLWrite(t *testing.T) {
+        config = defaultConfig()
+        config.SQLUpdate.Tables = []string{"device", "enduser"}
+        config.SQLUpdate.MaxRows = 10
+
+        tests := []struct {
+                name    string
+                sql     string
+                table   string
+                preview string
+                exec    string
+                err     string
+        }{
+                {
+                        name:    "update",
+                        sql:     "UPDATE device SET description = 'Lobby' WHERE name = 'SEP001122334455';",
+                        table:   "device",
+                        preview: "SELECT FIRST 11 * FROM device WHERE name = 'SEP001122334455'",
+                        exec:    "UPDATE device SET description = 'Lobby' WHERE name = 'SEP001122334455'",
+                },
+                {
+                        name:    "delete",
+                        sql:     "DELETE FROM enduser WHERE userid = 'jdoe'",
+                        table:   "enduser",
+                        preview: "SELECT FIRST 11 * FROM enduser WHERE userid = 'jdoe'",
+                        exec:    "DELETE FROM enduser WHERE userid = 'jdoe'",
+                },
+                {
+                        name:    "mixed case table",
+                        sql:     "update DeViCe set description = 'x' where name = 'a'",
+                        table:   "device",
+                        preview: "SELECT FIRST 11 * FROM device WHERE name = 'a'",
+                        exec:    "UPDATE device SET description = 'x' WHERE name = 'a'",
+                },
+                {
+                        name:    "set subquery with where",
+                        sql:     "UPDATE device SET description = (SELECT name FROM devicepool WHERE pkid = device.fkdevicepool) WHERE name = 'a'",
+                        table:   "device",
+                        preview: "SELECT FIRST 11 * FROM device WHERE name = 'a'",
+                },
+                {
+                        name:    "where inside a literal",
+                        sql:     "UPDATE device SET description = 'x where y' WHERE name = 'a'",
+                        table:   "device",
+                        preview: "SELECT FIRST 11 * FROM device WHERE name = 'a'",
+                },
+                {
+                        name:    "semicolon inside a literal",
+                        sql:     "UPDATE device SET description = 'a;b' WHERE name = 'a'",
+                        table:   "device",
+                        preview: "SELECT FIRST 11 * FROM device WHERE name = 'a'",
+                },
+                {
+                        name: "semicolon outside literals",
+                        sql:  "UPDATE device SET description = 'a' WHERE name = 'a'; DELETE FROM device WHERE 1 = 1",
+                        err:  "single UPDATE or DELETE",
+                },
+                {
+                        name: "update without where",
+                        sql:  "UPDATE device SET description = 'a'",
+                        err:  "only UPDATE",
+                },
+                {
+                        name: "where only in the set subquery",
+                        sql:  "UPDATE device SET description = (SELECT name FROM devicepool WHERE pkid = device.fkdevicepool)",
+                        err:  "only UPDATE",
+                },
+                {
+                        name: "delete without where",
+                        sql:  "DELETE FROM device",
+                        err:  "only UPDATE",
+                },
+                {
+                        name: "table not allowed",
+                        sql:  "DELETE FROM numplan WHERE dnorpattern = '1000'",
+                        err:  "not in sqlUpdate.tables",
+                },
+                {
+                        name:    "comment markers inside literals",
+                        sql:     "UPDATE device SET description = '-- /* { x' WHERE name = '} */'",
+                        table:   "device",
+                        preview: "SELECT FIRST 11 * FROM device WHERE name = '} */'",
+                        exec:    "UPDATE device SET description = '-- /* { x' WHERE name = '} */'",
+                },
+                {
+                        name: "line comment hiding the where",
+                        sql:  "UPDATE device SET description = 'x' -- WHERE name = 'a'",
+                        err:  "comments are not allowed",
+                },
+                {
+                        name: "block comment hiding the where",
+                        sql:  "DELETE FROM device /* WHERE name = 'a' */",
+                        err:  "comments are not allowed",
+                },
+                {
+                        name: "brace comment hiding the where",
+                        sql:  "DELETE FROM device { WHERE name = 'a' }",
+                        err:  "comments are not allowed",
+                },
+                {
+                        name: "trailing line comment",
+                        sql:  "DELETE FROM device WHERE name = 'a' -- note",
+                        err:  "comments are not allowed",
+                },
+                {
+                        name: "select",
+                        sql:  "SELECT * FROM device WHERE name = 'a'",
+                        err:  "only UPDATE",
+                },
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        w, err := parseSQLWrite(tt.sql)
+                        if tt.err != "" {
+                                if err == nil || !strings.Contains(err.Error(), tt.err) {
+                                        t.Fatalf("got error %v, want one containing %q", err, tt.err)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatalf("unexpected error: %v", err)
+                        }
+                        if w.Table != tt.table {
+                                t.Errorf("table = %q, want %q", w.Table, tt.table)
+                        }
+                        if got := w.previewSQL(); got != tt.preview {
+                                t.Errorf("preview = %q, want %q", got, tt.preview)
+                        }
+                        if tt.exec != "" {
+                                if got := w.statementSQL(); got != tt.exec {
+                                        t.Errorf("statement = %q, want %q", got, tt.exec)
+                                }
+                        }
+                })
+        }
+}
+
+func TestCheckSQLUpdateToken(t *testing.T) {
+        config = defaultConfig()
+        config.SQLUpdate.Secret = "test-secret"
+
+        const sql = "UPDATE device SET description = 'x' WHERE name = 'a'"
+        rows := []SQLRow{{"name": "a", "description": "old"}, {"name": "b", "description": "old"}}
+        token := sqlUpdateToken("hq", sql, rows, time.Now().Add(time.Minute))
+
+        tests := []struct {
+                name    string
+                token   string
+                cluster string
+                sql     string
+                rows    []SQLRow
+                err     string
+        }{
+                {name: "valid", token: token, cluster: "hq", sql: sql, rows: rows},
+                {
+                        name:    "rows in another order",
+                        token:   token,
+                        cluster: "hq",
+                        sql:     sql,
+                        rows:    []SQLRow{rows[1], rows[0]},
+                },
+                {
+                        name:    "expired",
+                        token:   sqlUpdateToken("hq", sql, rows, time.Now().Add(-time.Second)),
+                        cluster: "hq",
+                        sql:     sql,
+                        rows:    rows,
+                        err:     "expired",
+                },
+                {name: "another cluster", token: token, cluster: "branch", sql: sql, rows: rows, err: "does not match"},
+                {
+                        name:    "another statement",
+                        token:   token,
+                        cluster: "hq",
+                        sql:     "UPDATE device SET description = 'y' WHERE name = 'a'",
+                        rows:    rows,
+                        err:     "does not match",
+                },
+                {
+                        name:    "changed preview rows",
+                        token:   token,
+                        cluster: "hq",
+                        sql:     sql,
+                        rows:    []SQLRow{{"name": "a", "description": "new"}, rows[1]},
+                        err:     "does not match",
+                },
+                {name: "row added", token: token, cluster: "hq", sql: sql, rows: append(rows[:2:2], SQLRow{"name": "c"}), err: "does not match"},
+                {name: "malformed", token: "not-a-token", cluster: "hq", sql: sql, rows: rows, err: "malformed"},
+                {
+                        name:    "moved expiry",
+                        token:   strings.Replace(token, strings.Split(token, ".")[0], "9999999999", 1),
+                        cluster: "hq",
+                        sql:     sql,
+                        rows:    rows,
+                        err:     "does not match",
+                },
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        err := checkSQLUpdateToken(tt.token, tt.cluster, tt.sql, tt.rows)
+                        if tt.err == "" {
+                                if err != nil {
+                                        t.Fatalf("unexpected error: %v", err)
+                                }
+                                return
+                        }
+                        if err == nil || !strings.Contains(err.Error(), tt.err) {
+                                t.Fatalf("got error %v, want one containing %q", err, tt.err)
+                        }
+                })
+        }
+}
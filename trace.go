@@ -0,0 +1,195 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "crypto/rand"
+        "encoding/hex"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "regexp"
+        "strings"
+        "sync"
+        "time"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// axlTrace is the record logged for every AXL request sent to a node; it never holds the envelopes
+type axlTrace struct {
+        RequestID  string `json:"requestId,omitempty"`
+        Cluster    string `json:"cluster"`
+        Node       string `json:"node"`
+        Operation  string `json:"operation"`
+        Attempt    int    `json:"attempt"`
+        Lane       string `json:"lane"`
+        DurationMs int64  `json:"durationMs"`
+        // Status is the HTTP status CUCM answered with, 0 when the node could not be reached
+        Status    int    `json:"status"`
+        FaultCode string `json:"faultCode,omitempty"`
+        Error     string `json:"error,omitempty"`
+}
+
+// Context key for the request ID
+type requestIDKey struct{}
+
+// Header that carries the request ID in and out
+const requestIDHeader = "X-Request-ID"
+
+// Value written in place of a redacted element
+const redactedValue = "********"
+
+var (
+        // a request ID supplied by the client is only kept when it cannot break a log line
+        requestIDPattern  = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+        redactNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+        traceDebugMu sync.Mutex
+)
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to build the patterns that match the value of every name in trace.redact: as an element, with or
+// without a namespace prefix, and as a column compared or assigned in SQL text such as SET pin = '1234'
+func compileRedactPattern(names []string) (elements, sql *regexp.Regexp, err error) {
+        quoted := make([]string, 0, len(names))
+        for _, name := range names {
+                if !redactNamePattern.MatchString(name) {
+                        return nil, nil, fmt.Errorf("%q is not an XML element name", name)
+                }
+                quoted = append(quoted, regexp.QuoteMeta(name))
+        }
+        if len(quoted) == 0 {
+                return nil, nil, nil
+        }
+        alternatives := strings.Join(quoted, "|")
+
+        elements, err = regexp.Compile(`(?i)(<(?:[\w.-]+:)?(?:` + alternatives + `)(?:\s[^>/]*)?>)[^<]+`)
+        if err != nil {
+                return nil, nil, err
+        }
+        // inside an envelope the quotes of a SQL literal are usually escaped as &#39;
+        sql, err = regexp.Compile(`(?i)(\b(?:\w+\.)?(?:` + alternatives + `)\s*(?:=|<>|!=|\blike\s)\s*)(?:('|&#39;|&apos;).*?('|&#39;|&apos;)|[^\s,)<&']+)`)
+        if err != nil {
+                return nil, nil, err
+        }
+        return elements, sql, nil
+}
+
+// Function to mask the values of the trace.redact names in an envelope, both as elements and in SQL text
+func (t *TraceConfig) redact(envelope string) string {
+        if t.redactRegexp == nil {
+                return envelope
+        }
+        envelope = t.redactRegexp.ReplaceAllString(envelope, "${1}"+redactedValue)
+        return t.redactSQLRegexp.ReplaceAllString(envelope, "${1}${2}"+redactedValue+"${3}")
+}
+
+// Function to generate a request ID for requests that arrive without one
+func newRequestID() string {
+        b := make([]byte, 8)
+        rand.Read(b)
+        return hex.EncodeToString(b)
+}
+
+// Function to attach a request ID to a context
+func withRequestID(ctx context.Context, id string) context.Context {
+        return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Function to find the request ID of a request; commands run outside a request have none
+func requestIDFrom(ctx context.Context) string {
+        id, _ := ctx.Value(requestIDKey{}).(string)
+        return id
+}
+
+// Function to record one AXL exchange with a node: a trace line in the log when trace.enabled is set,
+// and the redacted envelopes in trace.debugFile when one is configured
+func traceAXL(ctx context.Context, cl *Cluster, node *clusterNode, op string, attempt int, start time.Time, request string, response []byte, err error) {
+        trace := axlTrace{
+                RequestID:  requestIDFrom(ctx),
+                Cluster:    cl.Name,
+                Node:       node.Host,
+                Operation:  op,
+                Attempt:    attempt,
+                Lane:       laneFrom(ctx).String(),
+                DurationMs: time.Since(start).Milliseconds(),
+        }
+
+        var fault *AXLFault
+        switch {
+        case errors.As(err, &fault):
+                trace.Status = fault.HTTPStatus
+                trace.FaultCode = fault.FaultCode
+                if fault.AXLCode != 0 {
+                        trace.FaultCode = fmt.Sprint(fault.AXLCode)
+                }
+                trace.Error = fault.Error()
+        case err != nil:
+                trace.Error = err.Error()
+        default:
+                trace.Status = http.StatusOK
+        }
+
+        line, _ := json.Marshal(trace)
+        if config.Trace.Enabled {
+                log.Printf("AXL trace %s", line)
+        }
+        if config.Trace.DebugFile != "" {
+                writeTraceDebug(line, config.Trace.redact(request), config.Trace.redact(string(response)))
+        }
+}
+
+// Function to append a trace and its redacted envelopes to the debug file
+func writeTraceDebug(trace []byte, request, response string) {
+        traceDebugMu.Lock()
+        defer traceDebugMu.Unlock()
+
+        f, err := os.OpenFile(config.Trace.DebugFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+        if err != nil {
+                logResponse("error", "Failed to open AXL debug file: "+err.Error())
+                return
+        }
+        defer f.Close()
+
+        _, err = fmt.Fprintf(f, "=== %s %s\n--- request\n%s\n--- response\n%s\n\n", time.Now().UTC().Format(time.RFC3339Nano), trace, request, response)
+        if err != nil {
+                logResponse("error", "Failed to write AXL debug file: "+err.Error())
+        }
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Function to give every request an ID, taken from X-Request-ID when the client sends a usable one,
+// and echo it in the response so client logs can be matched with the AXL traces
+func requestIDRouter(next http.Handler) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                id := r.Header.Get(requestIDHeader)
+                if !requestIDPattern.MatchString(id) {
+                        id = newRequestID()
+                }
+                w.Header().Set(requestIDHeader, id)
+                next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+        })
+}
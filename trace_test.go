@@ -0,0 +1,53 @@
+package main
+
+import (
+        "encoding/xml"
+        "testing"
+)
+
+func TestRedact(t *testing.T) {
+        elements, sql, err := compileRedactPattern(defaultConfig().Trace.Redact)
+        if err != nil {
+                t.Fatal(err)
+        }
+        trace := TraceConfig{redactRegexp: elements, redactSQLRegexp: sql}
+
+        marshal := func(v interface{}) string {
+                b, err := xml.Marshal(v)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                return string(b)
+        }
+
+        tests := []struct {
+                name string
+                in   string
+                want string
+        }{
+                {name: "element", in: "<password>secret</password>", want: "<password>********</password>"},
+                {name: "namespace prefix and case", in: `<axl:PIN xsi:nil="false">1234</axl:PIN>`, want: `<axl:PIN xsi:nil="false">********</axl:PIN>`},
+                {name: "other elements kept", in: "<userid>jdoe</userid><pinned>t</pinned>", want: "<userid>jdoe</userid><pinned>t</pinned>"},
+                {
+                        name: "sql set in an envelope",
+                        in:   marshal(&ExecuteSQLUpdateReq{SQL: "UPDATE enduser SET pin = '1234', lastname = 'Doe' WHERE userid = 'jdoe'"}),
+                        want: "<axl:executeSQLUpdate><sql>UPDATE enduser SET pin = &#39;********&#39;, lastname = &#39;Doe&#39; WHERE userid = &#39;jdoe&#39;</sql></axl:executeSQLUpdate>",
+                },
+                {
+                        name: "sql comparison with an alias",
+                        in:   "<sql>SELECT userid FROM enduser e WHERE e.password='hunter2' AND e.userid = 'jdoe'</sql>",
+                        want: "<sql>SELECT userid FROM enduser e WHERE e.password='********' AND e.userid = 'jdoe'</sql>",
+                },
+                {name: "sql unquoted value", in: "<sql>UPDATE enduser SET pin=1234 WHERE userid = 'jdoe'</sql>", want: "<sql>UPDATE enduser SET pin=******** WHERE userid = 'jdoe'</sql>"},
+                {name: "sql like", in: "<sql>SELECT 1 FROM enduser WHERE password LIKE 'a%'</sql>", want: "<sql>SELECT 1 FROM enduser WHERE password LIKE '********'</sql>"},
+                {name: "sql other columns kept, a look-alike inside a literal masked", in: "<sql>UPDATE device SET pinned = 't', description = 'pin = 1' WHERE name = 'a'</sql>", want: "<sql>UPDATE device SET pinned = 't', description = 'pin = ********' WHERE name = 'a'</sql>"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        if got := trace.redact(tt.in); got != tt.want {
+                                t.Errorf("redact(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+                        }
+                })
+        }
+}
@@ -0,0 +1,357 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "crypto/sha256"
+        "crypto/tls"
+        "crypto/x509"
+        "encoding/base64"
+        "encoding/hex"
+        "encoding/json"
+        "errors"
+        "flag"
+        "fmt"
+        "net"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// certPin is the SHA-256 of a certificate's public key (SPKI) or of the whole certificate
+type certPin struct {
+        spki bool
+        sum  [sha256.Size]byte
+}
+
+// Prefix of SPKI pins, as used by curl --pinnedpubkey and HPKP
+const spkiPinPrefix = "sha256/"
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to parse a pin: "sha256/<base64>" is an SPKI hash, anything else a hex certificate
+// fingerprint with optional colons, as printed by openssl x509 -fingerprint -sha256
+func parseCertPin(s string) (certPin, error) {
+        var p certPin
+        var sum []byte
+        var err error
+        if b64, ok := strings.CutPrefix(s, spkiPinPrefix); ok {
+                p.spki = true
+                sum, err = base64.StdEncoding.DecodeString(b64)
+        } else {
+                sum, err = hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+        }
+        if err != nil || len(sum) != sha256.Size {
+                return p, fmt.Errorf("pin %q is neither sha256/<base64 SPKI hash> nor a SHA-256 fingerprint", s)
+        }
+        copy(p.sum[:], sum)
+        return p, nil
+}
+
+// Function to compute the SPKI pin of a certificate; it stays the same when the certificate is
+// renewed with the same key
+func spkiPin(cert *x509.Certificate) certPin {
+        return certPin{spki: true, sum: sha256.Sum256(cert.RawSubjectPublicKeyInfo)}
+}
+
+// Function to compute the SHA-256 fingerprint of a certificate
+func certFingerprint(cert *x509.Certificate) certPin {
+        return certPin{sum: sha256.Sum256(cert.Raw)}
+}
+
+// Function to check a certificate against the pin
+func (p certPin) matches(cert *x509.Certificate) bool {
+        if p.spki {
+                return spkiPin(cert) == p
+        }
+        return certFingerprint(cert) == p
+}
+
+// Function to write the pin in the form parseCertPin reads
+func (p certPin) String() string {
+        if p.spki {
+                return spkiPinPrefix + base64.StdEncoding.EncodeToString(p.sum[:])
+        }
+        parts := make([]string, len(p.sum))
+        for i, b := range p.sum {
+                parts[i] = fmt.Sprintf("%02X", b)
+        }
+        return strings.Join(parts, ":")
+}
+
+// Function to combine the pins from the config with those in the pins file, by host
+func (t *TLSConfig) loadPins() (map[string][]certPin, error) {
+        all := make(map[string][]string)
+        for host, pins := range t.Pins {
+                all[host] = append(all[host], pins...)
+        }
+        if t.PinsFile != "" {
+                stored, err := readPinsFile(t.PinsFile)
+                if err != nil {
+                        return nil, err
+                }
+                for host, pins := range stored {
+                        all[host] = append(all[host], pins...)
+                }
+        }
+
+        parsed := make(map[string][]certPin, len(all))
+        var errs []error
+        for host, pins := range all {
+                for _, s := range pins {
+                        p, err := parseCertPin(s)
+                        if err != nil {
+                                errs = append(errs, fmt.Errorf("%s: %v", host, err))
+                                continue
+                        }
+                        parsed[host] = append(parsed[host], p)
+                }
+        }
+        return parsed, errors.Join(errs...)
+}
+
+// Function to read a pins file; a file that does not exist yet holds no pins
+func readPinsFile(path string) (map[string][]string, error) {
+        pins := make(map[string][]string)
+        data, err := os.ReadFile(path)
+        if errors.Is(err, os.ErrNotExist) {
+                return pins, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        if err := json.Unmarshal(data, &pins); err != nil {
+                return nil, fmt.Errorf("pins file %s: %v", path, err)
+        }
+        return pins, nil
+}
+
+// Function to write a pins file, replacing it only once the new content is complete
+func writePinsFile(path string, pins map[string][]string) error {
+        data, err := json.MarshalIndent(pins, "", "  ")
+        if err != nil {
+                return err
+        }
+        tmp, err := os.CreateTemp(filepath.Dir(path), ".axl-pins-*")
+        if err != nil {
+                return err
+        }
+        defer os.Remove(tmp.Name())
+        if _, err := tmp.Write(append(data, '\n')); err != nil {
+                tmp.Close()
+                return err
+        }
+        if err := tmp.Close(); err != nil {
+                return err
+        }
+        return os.Rename(tmp.Name(), path)
+}
+
+// Function to build the TLS settings for one node: a pinned node is checked with verifyPinnedChain,
+// any other node is verified against caFile (or the system roots)
+func (t *TLSConfig) clientConfig(host string) *tls.Config {
+        pins := t.pins[host]
+        if len(pins) == 0 {
+                return &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify, RootCAs: t.rootCAs}
+        }
+
+        return &tls.Config{
+                // the chain is not verified against a CA; the pins take its place
+                InsecureSkipVerify: true,
+                VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+                        return verifyPinnedChain(host, pins, rawCerts)
+                },
+        }
+}
+
+// Function to check the certificates a pinned node presented. The leaf (the first certificate) must
+// match a pin itself, or chain up to a pinned CA or intermediate that it was signed by and name the
+// host; a pinned certificate that is merely present in the chain proves nothing, since anyone can send it
+func verifyPinnedChain(host string, pins []certPin, rawCerts [][]byte) error {
+        if len(rawCerts) == 0 {
+                return fmt.Errorf("%s presented no certificate", host)
+        }
+        certs := make([]*x509.Certificate, len(rawCerts))
+        for i, raw := range rawCerts {
+                cert, err := x509.ParseCertificate(raw)
+                if err != nil {
+                        return err
+                }
+                certs[i] = cert
+        }
+
+        leaf := certs[0]
+        if matchesAnyPin(leaf, pins) {
+                return nil
+        }
+
+        pinned, others := x509.NewCertPool(), x509.NewCertPool()
+        anchors := 0
+        for _, cert := range certs[1:] {
+                if matchesAnyPin(cert, pins) {
+                        pinned.AddCert(cert)
+                        anchors++
+                } else {
+                        others.AddCert(cert)
+                }
+        }
+        if anchors > 0 {
+                _, err := leaf.Verify(x509.VerifyOptions{
+                        DNSName:       host,
+                        Roots:         pinned,
+                        Intermediates: others,
+                        KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+                })
+                if err == nil {
+                        return nil
+                }
+                return fmt.Errorf("certificate of %s (%s) does not chain to its pinned certificate: %v", host, spkiPin(leaf), err)
+        }
+        return fmt.Errorf("certificate of %s (%s) matches none of its pins; if it was renewed, run \"cm-gator trust -replace\"", host, spkiPin(leaf))
+}
+
+// Function to check a certificate against every pin of a host
+func matchesAnyPin(cert *x509.Certificate, pins []certPin) bool {
+        for _, p := range pins {
+                if p.matches(cert) {
+                        return true
+                }
+        }
+        return false
+}
+
+// Function to add a hint to certificate verification failures
+func explainTLSError(err error) error {
+        var verr *tls.CertificateVerificationError
+        if errors.As(err, &verr) {
+                return fmt.Errorf("%v (set axl.tls.caFile or pin the certificate with \"cm-gator trust\")", err)
+        }
+        return err
+}
+
+// Function to fetch the certificate a node presents, without verifying it
+func fetchNodeCertificate(node *clusterNode, timeout time.Duration) (*x509.Certificate, error) {
+        addr := net.JoinHostPort(node.Host, strconv.Itoa(node.Port))
+        conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, &tls.Config{
+                InsecureSkipVerify: true,
+                ServerName:         node.Host,
+        })
+        if err != nil {
+                return nil, err
+        }
+        defer conn.Close()
+
+        certs := conn.ConnectionState().PeerCertificates
+        if len(certs) == 0 {
+                return nil, fmt.Errorf("%s presented no certificate", addr)
+        }
+        return certs[0], nil
+}
+
+// Function to run "cm-gator trust [-cluster name|all] [-replace]": fetch each node's certificate
+// and store its SPKI pin in tls.pinsFile, so later connections only trust that key
+func runTrustCommand(args []string) error {
+        fs := flag.NewFlagSet("trust", flag.ContinueOnError)
+        cluster := fs.String("cluster", clusterAll, "cluster whose nodes to trust, or \"all\"")
+        replace := fs.Bool("replace", false, "replace stored pins that no longer match (after a certificate renewal)")
+        if err := fs.Parse(args); err != nil {
+                return err
+        }
+
+        clusters := allClusters()
+        if *cluster != clusterAll {
+                cl := config.clusters[*cluster]
+                if cl == nil {
+                        return fmt.Errorf("unknown cluster %q", *cluster)
+                }
+                clusters = []*Cluster{cl}
+        }
+
+        // clusters may share a pins file, so every file is read once and written once at the end
+        files := make(map[string]map[string][]string)
+        changed := make(map[string]bool)
+        failed := 0
+        for _, cl := range clusters {
+                path := cl.AXL.TLS.PinsFile
+                if path == "" {
+                        return fmt.Errorf("cluster %s: set axl.tls.pinsFile to store the pins", cl.Name)
+                }
+                if files[path] == nil {
+                        stored, err := readPinsFile(path)
+                        if err != nil {
+                                return err
+                        }
+                        files[path] = stored
+                }
+                stored := files[path]
+
+                for _, node := range cl.nodes {
+                        cert, err := fetchNodeCertificate(node, cl.AXL.Timeouts.Connect)
+                        if err != nil {
+                                fmt.Printf("%s %s %s: %v\n\n", cl.Name, node.role(), node.Host, err)
+                                failed++
+                                continue
+                        }
+                        pin := spkiPin(cert)
+                        fmt.Printf("%s %s %s:%d\n", cl.Name, node.role(), node.Host, node.Port)
+                        fmt.Printf("  subject      %s\n", cert.Subject)
+                        fmt.Printf("  issuer       %s\n", cert.Issuer)
+                        fmt.Printf("  expires      %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+                        fmt.Printf("  fingerprint  %s\n", certFingerprint(cert))
+                        fmt.Printf("  pin          %s\n", pin)
+
+                        known := cl.AXL.TLS.pins[node.Host]
+                        matched := false
+                        for _, p := range known {
+                                matched = matched || p.matches(cert)
+                        }
+                        switch {
+                        case matched:
+                                fmt.Printf("  already trusted\n\n")
+                        case len(known) == 0 || *replace:
+                                if len(known) > 0 && len(cl.AXL.TLS.Pins[node.Host]) > 0 {
+                                        fmt.Printf("  warning: axl.tls.pins in the config still lists the old pins for this host\n")
+                                }
+                                stored[node.Host] = []string{pin.String()}
+                                changed[path] = true
+                                fmt.Printf("  pinned in %s\n\n", path)
+                        default:
+                                fmt.Printf("  DOES NOT MATCH the stored pins; check the certificate, then run again with -replace\n\n")
+                                failed++
+                        }
+                }
+        }
+
+        paths := make([]string, 0, len(changed))
+        for path := range changed {
+                paths = append(paths, path)
+        }
+        sort.Strings(paths)
+        for _, path := range paths {
+                if err := writePinsFile(path, files[path]); err != nil {
+                        return err
+                }
+        }
+
+        if failed > 0 {
+                return fmt.Errorf("%d node(s) not trusted", failed)
+        }
+        return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+        "crypto"
+        "crypto/ecdsa"
+        "crypto/elliptic"
+        "crypto/rand"
+        "crypto/x509"
+        "crypto/x509/pkix"
+        "math/big"
+        "strings"
+        "testing"
+        "time"
+)
+
+// testCert is a generated certificate and its key
+type testCert struct {
+        raw  []byte
+        cert *x509.Certificate
+        key  crypto.Signer
+}
+
+// Function to generate a certificate for host with the given key, signed by parent (self-signed when nil)
+func newTestCert(t *testing.T, host string, key crypto.Signer, serial int64, isCA bool, parent *testCert) testCert {
+        t.Helper()
+        if key == nil {
+                var err error
+                if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+                        t.Fatal(err)
+                }
+        }
+        tmpl := &x509.Certificate{
+                SerialNumber:          big.NewInt(serial),
+                Subject:               pkix.Name{CommonName: host},
+                DNSNames:              []string{host},
+                NotBefore:             time.Now().Add(-time.Hour),
+                NotAfter:              time.Now().Add(time.Duration(serial) * 24 * time.Hour),
+                KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+                ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+                BasicConstraintsValid: true,
+                IsCA:                  isCA,
+        }
+        signerCert, signerKey := tmpl, key
+        if parent != nil {
+                signerCert, signerKey = parent.cert, parent.key
+        }
+        raw, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, key.Public(), signerKey)
+        if err != nil {
+                t.Fatal(err)
+        }
+        cert, err := x509.ParseCertificate(raw)
+        if err != nil {
+                t.Fatal(err)
+        }
+        return testCert{raw: raw, cert: cert, key: key}
+}
+
+func TestVerifyPinnedChain(t *testing.T) {
+        const host = "cucm-pub.example.com"
+        genuine := newTestCert(t, host, nil, 1, false, nil)
+        renewed := newTestCert(t, host, genuine.key, 2, false, nil)
+        attacker := newTestCert(t, host, nil, 1, false, nil)
+
+        ca := newTestCert(t, "Example CA", nil, 10, true, nil)
+        signed := newTestCert(t, host, nil, 3, false, &ca)
+        otherHost := newTestCert(t, "other.example.com", nil, 3, false, &ca)
+        otherCA := newTestCert(t, "Other CA", nil, 10, true, nil)
+        signedElsewhere := newTestCert(t, host, nil, 3, false, &otherCA)
+
+        spki := []certPin{spkiPin(genuine.cert)}
+        fingerprint := []certPin{certFingerprint(genuine.cert)}
+        caPin := []certPin{spkiPin(ca.cert)}
+
+        tests := []struct {
+                name  string
+                pins  []certPin
+                chain []testCert
+                err   string
+        }{
+                {name: "matching leaf by SPKI", pins: spki, chain: []testCert{genuine}},
+                {name: "matching leaf by fingerprint", pins: fingerprint, chain: []testCert{genuine}},
+                {name: "matching leaf with a chain", pins: spki, chain: []testCert{genuine, ca}},
+                {name: "non-matching leaf with the real certificate appended", pins: spki, chain: []testCert{attacker, genuine}, err: "does not chain"},
+                {name: "non-matching leaf with the fingerprinted certificate appended", pins: fingerprint, chain: []testCert{attacker, genuine}, err: "does not chain"},
+                {name: "non-matching leaf", pins: spki, chain: []testCert{attacker}, err: "matches none of its pins"},
+                {name: "renewed certificate with the same SPKI", pins: spki, chain: []testCert{renewed}},
+                {name: "renewed certificate against a fingerprint", pins: fingerprint, chain: []testCert{renewed}, err: "matches none of its pins"},
+                {name: "leaf signed by a pinned CA", pins: caPin, chain: []testCert{signed, ca}},
+                {name: "pinned CA certificate for another host", pins: caPin, chain: []testCert{otherHost, ca}, err: "does not chain"},
+                {name: "pinned CA appended to a leaf from another CA", pins: caPin, chain: []testCert{signedElsewhere, ca}, err: "does not chain"},
+                {name: "pinned CA without its certificate in the chain", pins: caPin, chain: []testCert{signed}, err: "matches none of its pins"},
+                {name: "no certificate", pins: spki, err: "presented no certificate"},
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        raw := make([][]byte, len(tt.chain))
+                        for i, c := range tt.chain {
+                                raw[i] = c.raw
+                        }
+                        err := verifyPinnedChain(host, tt.pins, raw)
+                        if tt.err == "" {
+                                if err != nil {
+                                        t.Fatalf("unexpected error: %v", err)
+                                }
+                                return
+                        }
+                        if err == nil || !strings.Contains(err.Error(), tt.err) {
+                                t.Fatalf("got error %v, want one containing %q", err, tt.err)
+                        }
+                })
+        }
+}
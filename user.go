@@ -0,0 +1,514 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/xml"
+        "fmt"
+        "net/http"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// AddUserReq is the end user accepted by /addUser, in AXL XUser element order
+type AddUserReq struct {
+        FirstName                       string            `json:"firstName" xml:"firstName,omitempty"`
+        DisplayName                     string            `json:"displayName" xml:"displayName,omitempty"`
+        MiddleName                      string            `json:"middleName" xml:"middleName,omitempty"`
+        LastName                        string            `json:"lastName" xml:"lastName"`
+        Userid                          string            `json:"userid" xml:"userid"`
+        Password                        string            `json:"password" xml:"password,omitempty"`
+        Pin                             string            `json:"pin" xml:"pin,omitempty"`
+        MailId                          string            `json:"mailid" xml:"mailid,omitempty"`
+        Department                      string            `json:"department" xml:"department,omitempty"`
+        Manager                         string            `json:"manager" xml:"manager,omitempty"`
+        UserLocale                      string            `json:"userLocale" xml:"userLocale,omitempty"`
+        AssociatedDevices               *Devices          `json:"associatedDevices" xml:"associatedDevices,omitempty"`
+        PrimaryExtension                *Extension        `json:"primaryExtension" xml:"primaryExtension,omitempty"`
+        AssociatedGroups                *AssociatedGroups `json:"associatedGroups" xml:"associatedGroups,omitempty"`
+        EnableCti                       *bool             `json:"enableCti" xml:"enableCti,omitempty"`
+        PresenceGroupName               string            `json:"presenceGroupName" xml:"presenceGroupName,omitempty"`
+        SubscribeCallingSearchSpaceName string            `json:"subscribeCallingSearchSpaceName" xml:"subscribeCallingSearchSpaceName,omitempty"`
+        EnableMobility                  *bool             `json:"enableMobility" xml:"enableMobility,omitempty"`
+        EnableMobileVoiceAccess         *bool             `json:"enableMobileVoiceAccess" xml:"enableMobileVoiceAccess,omitempty"`
+        TelephoneNumber                 string            `json:"telephoneNumber" xml:"telephoneNumber,omitempty"`
+        Title                           string            `json:"title" xml:"title,omitempty"`
+        MobileNumber                    string            `json:"mobileNumber" xml:"mobileNumber,omitempty"`
+        HomeNumber                      string            `json:"homeNumber" xml:"homeNumber,omitempty"`
+        PagerNumber                     string            `json:"pagerNumber" xml:"pagerNumber,omitempty"`
+        HomeCluster                     *bool             `json:"homeCluster" xml:"homeCluster,omitempty"`
+        ImAndPresenceEnable             *bool             `json:"imAndPresenceEnable" xml:"imAndPresenceEnable,omitempty"`
+        ServiceProfile                  string            `json:"serviceProfile" xml:"serviceProfile,omitempty"`
+        DirectoryUri                    string            `json:"directoryUri" xml:"directoryUri,omitempty"`
+}
+
+// Devices lists the devices associated with a user
+type Devices struct {
+        Device []string `json:"device" xml:"device"`
+}
+
+// Extension is a user's primary extension
+type Extension struct {
+        Pattern            string `json:"pattern" xml:"pattern"`
+        RoutePartitionName string `json:"routePartitionName" xml:"routePartitionName,omitempty"`
+}
+
+// AssociatedGroups lists the access control groups a user belongs to
+type AssociatedGroups struct {
+        UserGroup []UserGroup `json:"userGroup" xml:"userGroup"`
+}
+
+// UserGroup is an access control group membership
+type UserGroup struct {
+        Name      string     `json:"name" xml:"name"`
+        UserRoles *UserRoles `json:"userRoles,omitempty" xml:"userRoles,omitempty"`
+}
+
+// UserRoles lists the roles granted through an access control group
+type UserRoles struct {
+        UserRole []string `json:"userRole" xml:"userRole"`
+}
+
+// AddUserAXLReq structure for SOAP request
+type AddUserAXLReq struct {
+        XMLName xml.Name    `xml:"axl:addUser"`
+        User    *AddUserReq `xml:"user"`
+}
+
+// AddUserResp structure for SOAP response
+type AddUserResp struct {
+        Body struct {
+                AddUserResponse struct {
+                        Return string `xml:"return"`
+                } `xml:"addUserResponse"`
+        } `xml:"Body"`
+}
+
+// AssociatePhoneReq is the body accepted by /associatePhone
+type AssociatePhoneReq struct {
+        Name              string     `json:"name"`
+        OwnerUserName     string     `json:"ownerUserName"`
+        Userid            string     `json:"userid"`
+        AssociatedDevices []string   `json:"associatedDevices"`
+        PrimaryExtension  *Extension `json:"primaryExtension"`
+}
+
+// AssociatePhoneResult reports how far an association got
+type AssociatePhoneResult struct {
+        PhoneUpdated      bool     `json:"phoneUpdated"`
+        UserUpdated       bool     `json:"userUpdated"`
+        AssociatedDevices []string `json:"associatedDevices"`
+        FailedStep        string   `json:"failedStep,omitempty"`
+}
+
+// User is an end user as returned by AXL getUser; unrequested fields are left out of the JSON
+type User struct {
+        FirstName                       string                      `json:"firstName,omitempty" xml:"firstName,omitempty"`
+        DisplayName                     string                      `json:"displayName,omitempty" xml:"displayName,omitempty"`
+        MiddleName                      string                      `json:"middleName,omitempty" xml:"middleName,omitempty"`
+        LastName                        string                      `json:"lastName,omitempty" xml:"lastName,omitempty"`
+        Userid                          string                      `json:"userid,omitempty" xml:"userid,omitempty"`
+        MailId                          string                      `json:"mailid,omitempty" xml:"mailid,omitempty"`
+        Department                      string                      `json:"department,omitempty" xml:"department,omitempty"`
+        Manager                         string                      `json:"manager,omitempty" xml:"manager,omitempty"`
+        UserLocale                      string                      `json:"userLocale,omitempty" xml:"userLocale,omitempty"`
+        AssociatedDevices               *Devices                    `json:"associatedDevices,omitempty" xml:"associatedDevices,omitempty"`
+        PrimaryExtension                *Extension                  `json:"primaryExtension,omitempty" xml:"primaryExtension,omitempty"`
+        AssociatedGroups                *AssociatedGroups           `json:"associatedGroups,omitempty" xml:"associatedGroups,omitempty"`
+        EnableCti                       *bool                       `json:"enableCti,omitempty" xml:"enableCti,omitempty"`
+        PhoneProfiles                   *Profiles                   `json:"phoneProfiles,omitempty" xml:"phoneProfiles,omitempty"`
+        DefaultProfile                  string                      `json:"defaultProfile,omitempty" xml:"defaultProfile,omitempty"`
+        PresenceGroupName               string                      `json:"presenceGroupName,omitempty" xml:"presenceGroupName,omitempty"`
+        SubscribeCallingSearchSpaceName string                      `json:"subscribeCallingSearchSpaceName,omitempty" xml:"subscribeCallingSearchSpaceName,omitempty"`
+        EnableMobility                  *bool                       `json:"enableMobility,omitempty" xml:"enableMobility,omitempty"`
+        EnableMobileVoiceAccess         *bool                       `json:"enableMobileVoiceAccess,omitempty" xml:"enableMobileVoiceAccess,omitempty"`
+        Status                          string                      `json:"status,omitempty" xml:"status,omitempty"`
+        LineAppearanceAssociations      *LineAppearanceAssociations `json:"lineAppearanceAssociationForPresences,omitempty" xml:"lineAppearanceAssociationForPresences,omitempty"`
+        TelephoneNumber                 string                      `json:"telephoneNumber,omitempty" xml:"telephoneNumber,omitempty"`
+        Title                           string                      `json:"title,omitempty" xml:"title,omitempty"`
+        MobileNumber                    string                      `json:"mobileNumber,omitempty" xml:"mobileNumber,omitempty"`
+        HomeNumber                      string                      `json:"homeNumber,omitempty" xml:"homeNumber,omitempty"`
+        PagerNumber                     string                      `json:"pagerNumber,omitempty" xml:"pagerNumber,omitempty"`
+        HomeCluster                     *bool                       `json:"homeCluster,omitempty" xml:"homeCluster,omitempty"`
+        ImAndPresenceEnable             *bool                       `json:"imAndPresenceEnable,omitempty" xml:"imAndPresenceEnable,omitempty"`
+        ServiceProfile                  string                      `json:"serviceProfile,omitempty" xml:"serviceProfile,omitempty"`
+        DirectoryUri                    string                      `json:"directoryUri,omitempty" xml:"directoryUri,omitempty"`
+        LdapDirectoryName               string                      `json:"ldapDirectoryName,omitempty" xml:"ldapDirectoryName,omitempty"`
+}
+
+// Profiles lists the extension mobility profiles of a user
+type Profiles struct {
+        Profile []string `json:"profile" xml:"profile"`
+}
+
+// LineAppearanceAssociations lists the lines whose presence the user publishes
+type LineAppearanceAssociations struct {
+        LineAppearanceAssociationForPresence []LineAppearanceAssociationForPresence `json:"lineAppearanceAssociationForPresence" xml:"lineAppearanceAssociationForPresence"`
+}
+
+// LineAppearanceAssociationForPresence ties a line appearance to the user's presence
+type LineAppearanceAssociationForPresence struct {
+        LaapAssociate   string `json:"laapAssociate" xml:"laapAssociate"`
+        LaapProductType string `json:"laapProductType" xml:"laapProductType"`
+        LaapDeviceName  string `json:"laapDeviceName" xml:"laapDeviceName"`
+        LaapDirectory   string `json:"laapDirectory" xml:"laapDirectory"`
+        LaapPartition   string `json:"laapPartition" xml:"laapPartition"`
+        LaapDescription string `json:"laapDescription" xml:"laapDescription"`
+}
+
+// ListUserAXLReq structure for SOAP request
+type ListUserAXLReq struct {
+        XMLName        xml.Name           `xml:"axl:listUser"`
+        SearchCriteria UserSearchCriteria `xml:"searchCriteria"`
+        ReturnedTags   ReturnedTags       `xml:"returnedTags"`
+        Skip           int                `xml:"skip,omitempty"`
+        First          int                `xml:"first,omitempty"`
+}
+
+// UserSearchCriteria are the AXL listUser filters; % is the wildcard
+type UserSearchCriteria struct {
+        FirstName  string `xml:"firstName,omitempty"`
+        LastName   string `xml:"lastName,omitempty"`
+        Userid     string `xml:"userid,omitempty"`
+        Department string `xml:"department,omitempty"`
+}
+
+// ListUserResp structure for SOAP response
+type ListUserResp struct {
+        Body struct {
+                ListUserResponse struct {
+                        Return struct {
+                                User []User `xml:"user"`
+                        } `xml:"return"`
+                } `xml:"listUserResponse"`
+        } `xml:"Body"`
+}
+
+// Fields returned by /listUsers when the caller does not choose any
+var defaultUserListFields = []string{"userid", "firstName", "lastName", "department"}
+
+// GetUserReq is the body accepted by /getUser
+type GetUserReq struct {
+        Userid string   `json:"userid"`
+        Fields []string `json:"fields"`
+}
+
+// GetUserAXLReq structure for SOAP request
+type GetUserAXLReq struct {
+        XMLName      xml.Name     `xml:"axl:getUser"`
+        Userid       string       `xml:"userid"`
+        ReturnedTags ReturnedTags `xml:"returnedTags,omitempty"`
+}
+
+// GetUserResp structure for SOAP response
+type GetUserResp struct {
+        Body struct {
+                GetUserResponse struct {
+                        Return struct {
+                                User User `xml:"user"`
+                        } `xml:"return"`
+                } `xml:"getUserResponse"`
+        } `xml:"Body"`
+}
+
+// UpdateUserAXLReq structure for SOAP request
+type UpdateUserAXLReq struct {
+        XMLName           xml.Name   `xml:"axl:updateUser"`
+        Userid            string     `xml:"userid"`
+        AssociatedDevices *Devices   `xml:"associatedDevices,omitempty"`
+        PrimaryExtension  *Extension `xml:"primaryExtension,omitempty"`
+}
+
+/****
+*
+* Handlers
+*
+*/
+
+// Handler function for adding end users
+func handleAddUserRequest(w http.ResponseWriter, r *http.Request) {
+        var req AddUserReq
+        if err := decodeJSON(r, &req); err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+
+        if req.Userid == "" || req.LastName == "" {
+                errorResponse(w, http.StatusBadRequest, "userid and lastName are required", nil)
+                return
+        }
+
+        // A user homed on this cluster is the only kind that can be enabled for IM and Presence
+        if req.ImAndPresenceEnable != nil && *req.ImAndPresenceEnable && req.HomeCluster != nil && !*req.HomeCluster {
+                errorResponse(w, http.StatusBadRequest, "imAndPresenceEnable requires homeCluster", nil)
+                return
+        }
+
+        var resp AddUserResp
+        if err := callAXL(r.Context(), &AddUserAXLReq{User: &req}, &resp); err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "User added successfully", resp.Body.AddUserResponse.Return)
+}
+
+// Handler function for associating a phone with an end user
+func handleAssociatePhoneRequest(w http.ResponseWriter, r *http.Request) {
+        var req AssociatePhoneReq
+        if err := decodeJSON(r, &req); err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+
+        if req.Name == "" || req.Userid == "" {
+                errorResponse(w, http.StatusBadRequest, "name and userid are required", nil)
+                return
+        }
+        if req.OwnerUserName == "" {
+                req.OwnerUserName = req.Userid
+        }
+
+        // Read the current associations first so they are extended rather than replaced
+        var current GetUserResp
+        err := callAXL(r.Context(), &GetUserAXLReq{
+                Userid:       req.Userid,
+                ReturnedTags: ReturnedTags{"associatedDevices"},
+        }, &current)
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        result := AssociatePhoneResult{
+                AssociatedDevices: mergeDevices(current.Body.GetUserResponse.Return.User.AssociatedDevices, req.Name, req.AssociatedDevices),
+        }
+
+        owner, err := partialElements(&AddPhoneReq{OwnerUserName: req.OwnerUserName}, map[string]bool{"ownerUserName": true})
+        if err != nil {
+                errorResponse(w, http.StatusInternalServerError, "Failed to build request", nil)
+                logResponse("error", err.Error())
+                return
+        }
+
+        var phoneResp StandardResp
+        err = callAXL(r.Context(), &UpdatePhoneAXLReq{
+                Name:   req.Name,
+                Fields: owner,
+        }, &phoneResp)
+        if err != nil {
+                result.FailedStep = "updatePhone"
+                associateErrorResponse(w, "Failed to set phone owner; user was not changed", err, result)
+                return
+        }
+        result.PhoneUpdated = true
+
+        var userResp StandardResp
+        err = callAXL(r.Context(), &UpdateUserAXLReq{
+                Userid:            req.Userid,
+                AssociatedDevices: &Devices{Device: result.AssociatedDevices},
+                PrimaryExtension:  req.PrimaryExtension,
+        }, &userResp)
+        if err != nil {
+                result.FailedStep = "updateUser"
+                associateErrorResponse(w, "Phone owner was set but the user association failed", err, result)
+                return
+        }
+        result.UserUpdated = true
+
+        jsonResponse(w, http.StatusOK, "Phone associated successfully", result)
+}
+
+// Handler function for listing users
+func handleListUsersRequest(w http.ResponseWriter, r *http.Request) {
+        q := r.URL.Query()
+
+        skip, first, err := parsePage(r)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        fields := append([]string(nil), defaultUserListFields...)
+        if v := q.Get("fields"); v != "" {
+                fields = strings.Split(v, ",")
+        }
+        tags, err := returnedTagsFor(User{}, fields)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        criteria := UserSearchCriteria{
+                FirstName:  axlWildcard(q.Get("firstName")),
+                LastName:   axlWildcard(q.Get("lastName")),
+                Userid:     axlWildcard(q.Get("userid")),
+                Department: axlWildcard(q.Get("department")),
+        }
+        telephoneNumber := axlWildcard(q.Get("telephoneNumber"))
+        matchAllIfEmpty(&criteria, &criteria.Userid)
+
+        users, returned, err := listOnClusters(r.Context(), func(ctx context.Context) (interface{}, int, error) {
+                if telephoneNumber != "" {
+                        return listUsersByTelephoneNumber(ctx, criteria, telephoneNumber, tags, skip, first)
+                }
+
+                var resp ListUserResp
+                err := callAXL(ctx, &ListUserAXLReq{
+                        SearchCriteria: criteria,
+                        ReturnedTags:   tags,
+                        Skip:           skip,
+                        First:          first,
+                }, &resp)
+                if err != nil {
+                        return nil, 0, err
+                }
+
+                users := resp.Body.ListUserResponse.Return.User
+                if users == nil {
+                        users = []User{}
+                }
+                return users, len(users), nil
+        })
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonPageResponse(w, http.StatusOK, "Users retrieved successfully", users, nextCursor(skip, first, returned))
+}
+
+// Handler function for reading an end user
+func handleGetUserRequest(w http.ResponseWriter, r *http.Request) {
+        var req GetUserReq
+        if err := decodeJSON(r, &req); err != nil {
+                decodeErrorResponse(w, err)
+                return
+        }
+
+        if req.Userid == "" {
+                errorResponse(w, http.StatusBadRequest, "userid is required", nil)
+                return
+        }
+
+        tags, err := returnedTagsFor(User{}, req.Fields)
+        if err != nil {
+                errorResponse(w, http.StatusBadRequest, err.Error(), nil)
+                return
+        }
+
+        var resp GetUserResp
+        err = callAXL(r.Context(), &GetUserAXLReq{
+                Userid:       req.Userid,
+                ReturnedTags: tags,
+        }, &resp)
+        if err != nil {
+                axlErrorResponse(w, err)
+                return
+        }
+
+        jsonResponse(w, http.StatusOK, "User retrieved successfully", resp.Body.GetUserResponse.Return.User)
+}
+
+/****
+*
+* Helper functions
+*
+*/
+
+// Function to list users by telephone number, which is not a listUser criterion: the page of userids is
+// matched in enduser with executeSQLQuery, so skip and first count matching users, then each user is read
+// with getUser. Returns the users and the number of userids the page matched
+func listUsersByTelephoneNumber(ctx context.Context, criteria UserSearchCriteria, telephoneNumber string, tags ReturnedTags, skip, first int) ([]User, int, error) {
+        conds := sqlLikeConditions(nil, map[string]string{
+                "telephonenumber": telephoneNumber,
+                "firstname":       criteria.FirstName,
+                "lastname":        criteria.LastName,
+                "userid":          criteria.Userid,
+                "department":      criteria.Department,
+        })
+        sql := fmt.Sprintf("SELECT SKIP %d FIRST %d userid FROM enduser WHERE %s ORDER BY userid", skip, first, strings.Join(conds, " AND "))
+        rows, err := executeSQLQuery(ctx, sql)
+        if err != nil {
+                return nil, 0, err
+        }
+
+        userids := make([]string, len(rows))
+        for i, row := range rows {
+                userids[i] = row["userid"]
+        }
+        users, err := lookupEach(ctx, userids, func(ctx context.Context, userid string) (User, error) {
+                var resp GetUserResp
+                err := callAXL(ctx, &GetUserAXLReq{Userid: userid, ReturnedTags: tags}, &resp)
+                return resp.Body.GetUserResponse.Return.User, err
+        })
+        if err != nil {
+                return nil, 0, err
+        }
+        return users, len(rows), nil
+}
+
+// Function to append devices to a user's existing list without duplicates
+func mergeDevices(current *Devices, name string, extra []string) []string {
+        var merged []string
+        seen := make(map[string]bool)
+        add := func(device string) {
+                key := strings.ToUpper(device)
+                if device == "" || seen[key] {
+                        return
+                }
+                seen[key] = true
+                merged = append(merged, device)
+        }
+
+        if current != nil {
+                for _, device := range current.Device {
+                        add(device)
+                }
+        }
+        add(name)
+        for _, device := range extra {
+                add(device)
+        }
+        return merged
+}
+
+// Function to report whether a list contains a string
+func containsString(list []string, value string) bool {
+        for _, v := range list {
+                if v == value {
+                        return true
+                }
+        }
+        return false
+}
+
+// Function to return list without the values in drop
+func removeStrings(list, drop []string) []string {
+        var kept []string
+        for _, v := range list {
+                if !containsString(drop, v) {
+                        kept = append(kept, v)
+                }
+        }
+        return kept
+}
+
+// Function to report which half of an association failed
+func associateErrorResponse(w http.ResponseWriter, message string, err error, result AssociatePhoneResult) {
+        statusCode, resp := axlError(err)
+        resp.Message = message
+        resp.Details = result
+        logResponse("error", err.Error())
+        writeErrorResponse(w, statusCode, resp)
+}
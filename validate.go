@@ -0,0 +1,277 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "errors"
+        "fmt"
+        "net/http"
+        "net/url"
+        "reflect"
+        "regexp"
+        "strconv"
+        "strings"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// FieldViolation is one field that broke one of its validate rules
+type FieldViolation struct {
+        Field   string `json:"field"`
+        Rule    string `json:"rule"`
+        Message string `json:"message"`
+}
+
+// ValidationError lists every violation found in a request body
+type ValidationError struct {
+        Violations []FieldViolation `json:"violations"`
+}
+
+// validateRule checks field f of struct parent against the rule's argument and describes the problem,
+// or returns "" when the value is fine
+type validateRule func(f, parent reflect.Value, arg string) string
+
+var (
+        // Cisco hardware phones are registered under SEP and their MAC address
+        macDeviceNamePattern   = regexp.MustCompile(`^SEP[0-9A-Fa-f]{12}$`)
+        hardwareProductPattern = regexp.MustCompile(`^Cisco \d{4}`)
+)
+
+// Rules that may appear in a validate tag, e.g. validate:"oneof=User|Network" or validate:"min=1,max=200".
+// Apart from the required rules, a rule only checks a field that is set, since the zero value is left out of the AXL request
+var validateRules = map[string]validateRule{
+        "required": func(f, _ reflect.Value, _ string) string {
+                if f.IsZero() {
+                        return "is required"
+                }
+                return ""
+        },
+        // requiredwithout asks for the field unless a sibling field, named by its JSON key, is set instead
+        "requiredwithout": func(f, parent reflect.Value, arg string) string {
+                if other, ok := fieldByJSONName(parent, arg); f.IsZero() && (!ok || other.IsZero()) {
+                        return "is required when " + arg + " is not set"
+                }
+                return ""
+        },
+        "oneof": func(f, _ reflect.Value, arg string) string {
+                options := strings.Split(arg, "|")
+                if containsString(options, f.String()) {
+                        return ""
+                }
+                return "must be one of: " + strings.Join(options, ", ")
+        },
+        "min": func(f, _ reflect.Value, arg string) string {
+                if min, _ := strconv.ParseInt(arg, 10, 64); f.Int() < min {
+                        return "must be at least " + arg
+                }
+                return ""
+        },
+        "max": func(f, _ reflect.Value, arg string) string {
+                if max, _ := strconv.ParseInt(arg, 10, 64); f.Int() > max {
+                        return "must be at most " + arg
+                }
+                return ""
+        },
+        // lte compares with a sibling field, named by its JSON key, when that field is set as well
+        "lte": func(f, parent reflect.Value, arg string) string {
+                other, ok := fieldByJSONName(parent, arg)
+                if ok && !other.IsZero() && f.Int() > other.Int() {
+                        return "must not be greater than " + arg
+                }
+                return ""
+        },
+        // unique checks a list of structs for two items with the same value in the field named by its JSON key
+        "unique": func(f, _ reflect.Value, arg string) string {
+                seen := make(map[interface{}]bool, f.Len())
+                for i := 0; i < f.Len(); i++ {
+                        item, ok := fieldByJSONName(reflect.Indirect(f.Index(i)), arg)
+                        if !ok || item.IsZero() {
+                                continue
+                        }
+                        if seen[item.Interface()] {
+                                return fmt.Sprintf("has more than one item with %s %v", arg, item.Interface())
+                        }
+                        seen[item.Interface()] = true
+                }
+                return ""
+        },
+        "url": func(f, _ reflect.Value, _ string) string {
+                u, err := url.Parse(f.String())
+                if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+                        return "must be an absolute http or https URL"
+                }
+                return ""
+        },
+        // devicename applies the SEP<MAC> naming to SCCP and SIP hardware phones, named "Cisco <model>"
+        "devicename": func(f, parent reflect.Value, _ string) string {
+                product := parent.FieldByName("Product").String()
+                protocol := parent.FieldByName("Protocol").String()
+                if protocol != "SCCP" && protocol != "SIP" || !hardwareProductPattern.MatchString(product) {
+                        return ""
+                }
+                if !macDeviceNamePattern.MatchString(f.String()) {
+                        return fmt.Sprintf("must be SEP followed by the 12 hex digits of the MAC address for a %s", product)
+                }
+                return ""
+        },
+}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to check a request against the validate tags of its fields and of the structs it holds,
+// reporting every violation at once; partial skips the required rules of the top-level fields, for PATCH
+// bodies, while the items of a list that is sent must still be complete. A tag naming an unknown rule is
+// returned as a plain error rather than a ValidationError, since it is a fault of the server, not the client
+func validateRequest(v interface{}, partial bool) error {
+        var violations []FieldViolation
+        var tagErr error
+        walkValidateFields(reflect.ValueOf(v), "", func(f, parent reflect.Value, path, tag string) {
+                for _, rule := range strings.Split(tag, ",") {
+                        name, arg, _ := strings.Cut(rule, "=")
+                        check, ok := validateRules[name]
+                        if !ok {
+                                tagErr = fmt.Errorf("unknown validate rule %q on %s", name, path)
+                                continue
+                        }
+                        required := name == "required" || name == "requiredwithout"
+                        if required && partial && !strings.ContainsAny(path, ".[") || !required && f.IsZero() {
+                                continue
+                        }
+                        if msg := check(f, parent, arg); msg != "" {
+                                violations = append(violations, FieldViolation{Field: path, Rule: name, Message: msg})
+                                // the other rules say little about a value that broke one already
+                                break
+                        }
+                }
+        })
+
+        if tagErr != nil {
+                return tagErr
+        }
+        if len(violations) == 0 {
+                return nil
+        }
+        return &ValidationError{Violations: violations}
+}
+
+// Function to check that every validate tag of a type, and of the types it holds, names known rules
+func checkValidateTags(t reflect.Type) error {
+        return checkValidateTagsOf(t, make(map[reflect.Type]bool))
+}
+
+// Function to check the validate tags of one type, skipping types already seen
+func checkValidateTagsOf(t reflect.Type, seen map[reflect.Type]bool) error {
+        switch t.Kind() {
+        case reflect.Ptr, reflect.Slice, reflect.Array:
+                return checkValidateTagsOf(t.Elem(), seen)
+        case reflect.Struct:
+        default:
+                return nil
+        }
+        if seen[t] {
+                return nil
+        }
+        seen[t] = true
+
+        for i := 0; i < t.NumField(); i++ {
+                sf := t.Field(i)
+                if tag := sf.Tag.Get("validate"); tag != "" {
+                        for _, rule := range strings.Split(tag, ",") {
+                                name, _, _ := strings.Cut(rule, "=")
+                                if _, ok := validateRules[name]; !ok {
+                                        return fmt.Errorf("unknown validate rule %q on %s.%s", name, t.Name(), sf.Name)
+                                }
+                        }
+                }
+                if err := checkValidateTagsOf(sf.Type, seen); err != nil {
+                        return err
+                }
+        }
+        return nil
+}
+
+// Function to send a failed validation back: violations as 422, a broken validate tag as 500
+func validationErrorResponse(w http.ResponseWriter, err error) {
+        var verr *ValidationError
+        if errors.As(err, &verr) {
+                errorResponse(w, http.StatusUnprocessableEntity, err.Error(), verr)
+                return
+        }
+        errorResponse(w, http.StatusInternalServerError, err.Error(), nil)
+}
+
+// Function to visit every field carrying a validate tag, naming it by its JSON path
+func walkValidateFields(v reflect.Value, path string, fn func(f, parent reflect.Value, path, tag string)) {
+        switch v.Kind() {
+        case reflect.Ptr, reflect.Interface:
+                if !v.IsNil() {
+                        walkValidateFields(v.Elem(), path, fn)
+                }
+        case reflect.Slice, reflect.Array:
+                if k := v.Type().Elem().Kind(); k != reflect.Struct && k != reflect.Ptr {
+                        return
+                }
+                for i := 0; i < v.Len(); i++ {
+                        walkValidateFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+                }
+        case reflect.Struct:
+                t := v.Type()
+                for i := 0; i < t.NumField(); i++ {
+                        sf := t.Field(i)
+                        if !sf.IsExported() {
+                                continue
+                        }
+                        name := jsonFieldName(sf)
+                        if path != "" {
+                                name = path + "." + name
+                        }
+
+                        f := v.Field(i)
+                        if tag := sf.Tag.Get("validate"); tag != "" {
+                                fn(f, v, name, tag)
+                        }
+                        walkValidateFields(f, name, fn)
+                }
+        }
+}
+
+// Function to name a struct field by its JSON key
+func jsonFieldName(sf reflect.StructField) string {
+        name := strings.Split(sf.Tag.Get("json"), ",")[0]
+        if name == "" || name == "-" {
+                return sf.Name
+        }
+        return name
+}
+
+// Function to find the field of a struct that has the given JSON key
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+                if jsonFieldName(t.Field(i)) == name {
+                        return v.Field(i), true
+                }
+        }
+        return reflect.Value{}, false
+}
+
+// Function to describe the violations in one line
+func (e *ValidationError) Error() string {
+        parts := make([]string, len(e.Violations))
+        for i, v := range e.Violations {
+                parts[i] = v.Field + " " + v.Message
+        }
+        return "request validation failed: " + strings.Join(parts, "; ")
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+        "errors"
+        "reflect"
+        "testing"
+)
+
+// validateTestItem and validateTestReq carry one field per rule, in the shapes the request structs use
+type validateTestItem struct {
+        Index int    `json:"index" validate:"min=1"`
+        Dest  string `json:"dest" validate:"requiredwithout=dirn"`
+        Dirn  string `json:"dirn"`
+        Model string `json:"model" validate:"required"`
+}
+
+type validateTestReq struct {
+        Name     string             `json:"name" validate:"required,devicename"`
+        Product  string             `json:"product"`
+        Protocol string             `json:"protocol" validate:"oneof=SCCP|SIP"`
+        MaxCalls int                `json:"maxCalls" validate:"min=1,max=200"`
+        Busy     int                `json:"busy" validate:"lte=maxCalls"`
+        Url      string             `json:"url" validate:"url"`
+        Items    []validateTestItem `json:"items" validate:"unique=index"`
+}
+
+func TestValidateRequest(t *testing.T) {
+        valid := func() validateTestReq {
+                return validateTestReq{
+                        Name:     "SEP001122334455",
+                        Product:  "Cisco 8841",
+                        Protocol: "SIP",
+                        MaxCalls: 4,
+                        Busy:     2,
+                        Url:      "http://app.example.com/em",
+                        Items:    []validateTestItem{{Index: 1, Dirn: "1000", Model: "m"}, {Dest: "sip:a@b", Model: "m"}},
+                }
+        }
+
+        tests := []struct {
+                name    string
+                modify  func(r *validateTestReq)
+                partial bool
+                want    []string
+        }{
+                {name: "valid", modify: func(r *validateTestReq) {}},
+                {name: "required", modify: func(r *validateTestReq) { r.Name = "" }, want: []string{"name required"}},
+                {name: "required skipped in partial mode", modify: func(r *validateTestReq) { r.Name = "" }, partial: true},
+                {name: "oneof", modify: func(r *validateTestReq) { r.Protocol = "MGCP" }, want: []string{"protocol oneof"}},
+                {name: "oneof skips unset", modify: func(r *validateTestReq) { r.Protocol = "" }},
+                {name: "min", modify: func(r *validateTestReq) { r.MaxCalls, r.Busy = -1, 0 }, want: []string{"maxCalls min"}},
+                {name: "max", modify: func(r *validateTestReq) { r.MaxCalls = 201 }, want: []string{"maxCalls max"}},
+                {name: "lte", modify: func(r *validateTestReq) { r.Busy = 5 }, want: []string{"busy lte"}},
+                {name: "lte skips an unset sibling", modify: func(r *validateTestReq) { r.MaxCalls, r.Busy = 0, 5 }},
+                {name: "url", modify: func(r *validateTestReq) { r.Url = "app.example.com/em" }, want: []string{"url url"}},
+                {name: "url with another scheme", modify: func(r *validateTestReq) { r.Url = "ftp://app.example.com/em" }, want: []string{"url url"}},
+                {name: "url without a host", modify: func(r *validateTestReq) { r.Url = "http:///em" }, want: []string{"url url"}},
+                {name: "devicename", modify: func(r *validateTestReq) { r.Name = "Lobby" }, want: []string{"name devicename"}},
+                {name: "devicename skips software phones", modify: func(r *validateTestReq) { r.Name, r.Product = "CSFJDOE", "Cisco Unified Client Services Framework" }},
+                {
+                        name:   "unique",
+                        modify: func(r *validateTestReq) { r.Items[1].Index = 1 },
+                        want:   []string{"items unique"},
+                },
+                {
+                        name:   "unique ignores unset indexes",
+                        modify: func(r *validateTestReq) { r.Items[0].Index = 0 },
+                },
+                {
+                        name:   "min on a list item",
+                        modify: func(r *validateTestReq) { r.Items[1].Index = -2 },
+                        want:   []string{"items[1].index min"},
+                },
+                {
+                        name:   "requiredwithout",
+                        modify: func(r *validateTestReq) { r.Items[0].Dirn = "" },
+                        want:   []string{"items[0].dest requiredwithout"},
+                },
+                {
+                        name:    "list items are complete in partial mode",
+                        modify:  func(r *validateTestReq) { r.Name, r.Items[0].Dirn, r.Items[1].Model = "", "", "" },
+                        partial: true,
+                        want:    []string{"items[0].dest requiredwithout", "items[1].model required"},
+                },
+                {
+                        name: "every violation at once, first rule per field",
+                        modify: func(r *validateTestReq) {
+                                r.Name, r.Protocol, r.MaxCalls, r.Busy = "", "H.323", 300, 0
+                        },
+                        want: []string{"name required", "protocol oneof", "maxCalls max"},
+                },
+        }
+
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        req := valid()
+                        tt.modify(&req)
+                        err := validateRequest(&req, tt.partial)
+
+                        var got []string
+                        if err != nil {
+                                var verr *ValidationError
+                                if !errors.As(err, &verr) {
+                                        t.Fatalf("got %v, want a *ValidationError", err)
+                                }
+                                for _, v := range verr.Violations {
+                                        got = append(got, v.Field+" "+v.Rule)
+                                }
+                        }
+                        if !reflect.DeepEqual(got, tt.want) {
+                                t.Errorf("violations = %q, want %q", got, tt.want)
+                        }
+                })
+        }
+}
+
+func TestValidateTags(t *testing.T) {
+        if err := checkValidateTags(reflect.TypeOf(AddPhoneReq{})); err != nil {
+                t.Fatal(err)
+        }
+
+        type broken struct {
+                Items []struct {
+                        Name string `json:"name" validate:"requried"`
+                } `json:"items"`
+        }
+        if err := checkValidateTags(reflect.TypeOf(broken{})); err == nil {
+                t.Error("checkValidateTags accepted an unknown rule")
+        }
+        req := broken{Items: []struct {
+                Name string `json:"name" validate:"requried"`
+        }{{Name: "x"}}}
+        err := validateRequest(&req, false)
+        var verr *ValidationError
+        if err == nil || errors.As(err, &verr) {
+                t.Errorf("validateRequest with an unknown rule returned %v, want a plain error", err)
+        }
+}
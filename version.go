@@ -0,0 +1,320 @@
+package main
+
+/****
+*
+* Imports
+*
+*/
+
+import (
+        "context"
+        "encoding/xml"
+        "errors"
+        "fmt"
+        "log"
+        "reflect"
+        "strconv"
+        "strings"
+        "time"
+)
+
+/****
+*
+* Structures
+*
+*/
+
+// GetCCMVersionReq structure for SOAP request
+type GetCCMVersionReq struct {
+        XMLName xml.Name `xml:"axl:getCCMVersion"`
+}
+
+// GetCCMVersionResp structure for SOAP response
+type GetCCMVersionResp struct {
+        Body struct {
+                GetCCMVersionResponse struct {
+                        Return struct {
+                                ComponentVersion struct {
+                                        Version string `xml:"version"`
+                                } `xml:"componentVersion"`
+                        } `xml:"return"`
+                } `xml:"getCCMVersionResponse"`
+        } `xml:"Body"`
+}
+
+// UnsupportedFieldsError lists request fields that the cluster's AXL version does not have
+type UnsupportedFieldsError struct {
+        Version string   `json:"axlVersion"`
+        Fields  []string `json:"fields"`
+}
+
+// axl.version value that asks for detection with getCCMVersion at startup
+const axlVersionAuto = "auto"
+
+// Context key for the AXL settings, with the version being probed, used by getCCMVersion during detection
+type probeAXLKey struct{}
+
+// Returned for requests to a cluster whose AXL version could not be detected yet
+var ErrAXLVersionUnknown = errors.New("AXL version not detected")
+
+// AXL schema versions cm-gator can speak, oldest first
+var supportedAXLVersions = []string{"10.0", "10.5", "11.0", "11.5", "12.0", "12.5", "14.0", "15.0"}
+
+// Namespaces tried for getCCMVersion, most common first; any version the cluster accepts will do
+var axlProbeVersions = []string{"14.0", "12.5", "15.0", "11.5", "10.5"}
+
+/****
+*
+* Functions
+*
+*/
+
+// Function to replace version "auto" with the schema version matching each configured cluster; a cluster
+// that cannot be reached is logged and detected again on a request after AXL.HealthRetry
+func resolveAXLVersions() {
+        for _, cl := range allClusters() {
+                if err := cl.ensureAXLVersion(context.Background()); err != nil {
+                        log.Printf("Cluster %s: %v; detection is retried on a request after %s", cl.Name, err, cl.AXL.HealthRetry)
+                }
+        }
+}
+
+// Function to make sure the cluster's AXL version is known before a request is built for it. Only one
+// request detects it at a time; the others wait for that detection, or until their own context ends,
+// and a failure is returned without probing again until AXL.HealthRetry has passed
+func (cl *Cluster) ensureAXLVersion(ctx context.Context) error {
+        for {
+                cl.versionMu.Lock()
+                if cl.AXL.Version != axlVersionAuto {
+                        cl.versionMu.Unlock()
+                        return nil
+                }
+                if cl.versionErr != nil && time.Now().Before(cl.versionRetry) {
+                        err := cl.versionErr
+                        cl.versionMu.Unlock()
+                        return err
+                }
+                done := cl.versionDone
+                if done == nil {
+                        done = make(chan struct{})
+                        cl.versionDone = done
+                        cl.versionMu.Unlock()
+                        return cl.detectAXLVersion(ctx, done)
+                }
+                cl.versionMu.Unlock()
+
+                select {
+                case <-done:
+                case <-ctx.Done():
+                        return ctx.Err()
+                }
+        }
+}
+
+// Function to run the detection for ensureAXLVersion and record its outcome; a detection cut short by
+// the caller's context is not recorded, so the next request starts a new one
+func (cl *Cluster) detectAXLVersion(ctx context.Context, done chan struct{}) error {
+        version, err := cl.probeAXLVersions(withCluster(ctx, cl))
+
+        cl.versionMu.Lock()
+        defer cl.versionMu.Unlock()
+        cl.versionDone = nil
+        close(done)
+
+        switch {
+        case err == nil:
+                cl.AXL.Version = version
+                cl.versionErr = nil
+                return nil
+        case ctx.Err() != nil:
+                return ctx.Err()
+        }
+        cl.versionErr = fmt.Errorf("%w on cluster %s: %v", ErrAXLVersionUnknown, cl.Name, err)
+        cl.versionRetry = time.Now().Add(cl.AXL.HealthRetry)
+        return cl.versionErr
+}
+
+// Function to read the AXL version for display; a cluster still being detected shows as "auto"
+func (cl *Cluster) axlVersion() string {
+        cl.versionMu.Lock()
+        defer cl.versionMu.Unlock()
+        return cl.AXL.Version
+}
+
+// Function to find the schema version matching the cluster by trying getCCMVersion in each probe namespace
+func (cl *Cluster) probeAXLVersions(ctx context.Context) (string, error) {
+        var lastErr error
+        for _, probe := range axlProbeVersions {
+                var resp GetCCMVersionResp
+                if err := probeAXLVersion(ctx, cl, probe, &resp); err != nil {
+                        if ctx.Err() != nil {
+                                return "", err
+                        }
+                        lastErr = err
+                        continue
+                }
+
+                cucm := resp.Body.GetCCMVersionResponse.Return.ComponentVersion.Version
+                version, err := axlVersionFor(cucm)
+                if err != nil {
+                        return "", err
+                }
+                log.Printf("Cluster %s: detected CUCM %s, using AXL %s", cl.Name, cucm, version)
+                return version, nil
+        }
+        return "", fmt.Errorf("getCCMVersion failed (set version to skip detection): %v", lastErr)
+}
+
+// Function to send getCCMVersion with the version being probed. It is sent without callAXL, whose
+// field check waits for the version, and leaves the cluster's settings alone while others read them
+func probeAXLVersion(ctx context.Context, cl *Cluster, version string, resp *GetCCMVersionResp) error {
+        probe := cl.AXL
+        probe.Version = version
+        env := newEnvelope(cl, &GetCCMVersionReq{})
+        env.XmlnsAxl = probe.Namespace()
+
+        out, err := xml.Marshal(env)
+        if err != nil {
+                return fmt.Errorf("failed to marshal SOAP request: %v", err)
+        }
+        body, err := sendAXLRequest(context.WithValue(ctx, probeAXLKey{}, &probe), "getCCMVersion", string(out))
+        if err != nil {
+                return err
+        }
+        if err := xml.Unmarshal(body, resp); err != nil {
+                return fmt.Errorf("failed to parse response: %v", err)
+        }
+        return nil
+}
+
+// Function to pick the newest supported AXL schema not newer than a CUCM release, e.g. 12.5.1.14900-63 -> 12.5
+func axlVersionFor(cucm string) (string, error) {
+        parts := strings.SplitN(cucm, ".", 3)
+        if len(parts) < 2 {
+                return "", fmt.Errorf("unrecognised CUCM version %q", cucm)
+        }
+        release := parts[0] + "." + parts[1]
+        if _, ok := parseAXLVersion(release); !ok {
+                return "", fmt.Errorf("unrecognised CUCM version %q", cucm)
+        }
+
+        chosen := ""
+        for _, v := range supportedAXLVersions {
+                if compareAXLVersions(v, release) <= 0 {
+                        chosen = v
+                }
+        }
+        if chosen == "" {
+                return "", fmt.Errorf("CUCM %s is older than AXL %s, the oldest supported version", cucm, supportedAXLVersions[0])
+        }
+        return chosen, nil
+}
+
+// Function to split a "major.minor" version into numbers
+func parseAXLVersion(v string) ([2]int, bool) {
+        major, minor, found := strings.Cut(v, ".")
+        a, errA := strconv.Atoi(major)
+        b, errB := strconv.Atoi(minor)
+        return [2]int{a, b}, found && errA == nil && errB == nil
+}
+
+// Function to compare two "major.minor" versions, returning -1, 0 or 1
+func compareAXLVersions(a, b string) int {
+        va, _ := parseAXLVersion(a)
+        vb, _ := parseAXLVersion(b)
+        for i := range va {
+                if va[i] != vb[i] {
+                        if va[i] < vb[i] {
+                                return -1
+                        }
+                        return 1
+                }
+        }
+        return 0
+}
+
+// Function to find set fields tagged axlsince:"x.y" that are newer than the cluster's AXL version;
+// with axl.unsupportedFields "drop" they are cleared and returned, otherwise an error lists them
+func checkAXLFields(ctx context.Context, content interface{}) ([]string, error) {
+        // every request passes here before it is built, so this is where a missing version is detected
+        cl := clusterFrom(ctx)
+        if err := cl.ensureAXLVersion(ctx); err != nil {
+                return nil, err
+        }
+        version := cl.AXL.Version
+        if _, ok := parseAXLVersion(version); !ok {
+                return nil, nil
+        }
+        drop := cl.AXL.UnsupportedFields == "drop"
+
+        var found, described []string
+        var cannotDrop bool
+        walkAXLFields(reflect.ValueOf(content), "", func(f reflect.Value, path, since string) {
+                if compareAXLVersions(since, version) <= 0 {
+                        return
+                }
+                found = append(found, path)
+                described = append(described, path+" (AXL "+since+")")
+                if drop && f.CanSet() {
+                        f.Set(reflect.Zero(f.Type()))
+                } else {
+                        cannotDrop = true
+                }
+        })
+
+        if len(found) == 0 {
+                return nil, nil
+        }
+        if !drop || cannotDrop {
+                return nil, &UnsupportedFieldsError{Version: version, Fields: described}
+        }
+        log.Printf("Dropped fields not supported by AXL %s: %s", version, strings.Join(described, ", "))
+        return found, nil
+}
+
+// Function to visit every set field carrying an axlsince tag, naming it by its XML path
+func walkAXLFields(v reflect.Value, path string, fn func(f reflect.Value, path, since string)) {
+        switch v.Kind() {
+        case reflect.Ptr, reflect.Interface:
+                if !v.IsNil() {
+                        walkAXLFields(v.Elem(), path, fn)
+                }
+        case reflect.Slice, reflect.Array:
+                if k := v.Type().Elem().Kind(); k != reflect.Struct && k != reflect.Ptr {
+                        return
+                }
+                for i := 0; i < v.Len(); i++ {
+                        walkAXLFields(v.Index(i), fmt.Sprintf("%s[%d]", path, i), fn)
+                }
+        case reflect.Struct:
+                t := v.Type()
+                for i := 0; i < t.NumField(); i++ {
+                        sf := t.Field(i)
+                        if !sf.IsExported() || sf.Type == reflect.TypeOf(xml.Name{}) {
+                                continue
+                        }
+                        name := strings.Split(sf.Tag.Get("xml"), ",")[0]
+                        if name == "" || name == "-" {
+                                name = sf.Name
+                        }
+                        if i := strings.LastIndex(name, ">"); i >= 0 {
+                                name = name[i+1:]
+                        }
+                        if path != "" {
+                                name = path + "." + name
+                        }
+
+                        f := v.Field(i)
+                        if since := sf.Tag.Get("axlsince"); since != "" && !f.IsZero() {
+                                fn(f, name, since)
+                                continue
+                        }
+                        walkAXLFields(f, name, fn)
+                }
+        }
+}
+
+// Function to describe the unsupported fields
+func (e *UnsupportedFieldsError) Error() string {
+        return fmt.Sprintf("fields not supported by AXL %s: %s", e.Version, strings.Join(e.Fields, ", "))
+}